@@ -13,11 +13,15 @@ import (
 	"strings"
 
 	// Local:
+	"github.com/katosys/kato/pkg/apply"
 	"github.com/katosys/kato/pkg/cli"
+	"github.com/katosys/kato/pkg/dns"
 	"github.com/katosys/kato/pkg/ec2"
 	"github.com/katosys/kato/pkg/ns1"
 	"github.com/katosys/kato/pkg/pkt"
 	"github.com/katosys/kato/pkg/r53"
+	"github.com/katosys/kato/pkg/rfc2136"
+	"github.com/katosys/kato/pkg/state"
 	"github.com/katosys/kato/pkg/udata"
 
 	// Community:
@@ -55,6 +59,10 @@ func main() {
 	case pkt.RunCmd(command):
 	case ns1.RunCmd(command):
 	case r53.RunCmd(command):
+	case rfc2136.RunCmd(command):
+	case dns.RunCmd(command):
+	case apply.RunCmd(command):
+	case state.RunCmd(command):
 	}
 }
 
@@ -0,0 +1,53 @@
+package apply
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+	"github.com/katosys/kato/pkg/cli"
+)
+
+//-----------------------------------------------------------------------------
+// 'katoctl apply' command flags definitions:
+//-----------------------------------------------------------------------------
+
+var (
+
+	//--------------------------
+	// apply: top level command
+	//--------------------------
+
+	cmdApply = cli.App.Command("apply",
+		"Converge a cluster towards its declarative spec.")
+
+	flApplyFile = cmdApply.Flag("file",
+		"Cluster spec file in YAML or JSON format.").
+		Short('f').Required().PlaceHolder("KATO_APPLY_FILE").
+		OverrideDefaultFromEnvar("KATO_APPLY_FILE").
+		ExistingFile()
+)
+
+//-----------------------------------------------------------------------------
+// RunCmd:
+//-----------------------------------------------------------------------------
+
+// RunCmd runs the cmd if owned by this package.
+func RunCmd(cmd string) bool {
+
+	switch cmd {
+
+	// katoctl apply
+	case cmdApply.FullCommand():
+		d := Data{
+			File: *flApplyFile,
+		}
+		d.Apply()
+
+	// Nothing to do:
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,303 @@
+package apply
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	// Local:
+	"github.com/katosys/kato/pkg/cli"
+	"github.com/katosys/kato/pkg/ec2"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Data struct for the apply command.
+type Data struct {
+	command string
+	File    string
+	Spec
+}
+
+// Spec is a versioned declarative cluster definition.
+type Spec struct {
+	Version   string   `yaml:"version"`
+	ClusterID string   `yaml:"clusterID"`
+	Domain    string   `yaml:"domain"`
+	Provider  Provider `yaml:"provider"`
+	DNS       DNS      `yaml:"dns"`
+	Alerting  Alerting `yaml:"alerting"`
+	Features  Features `yaml:"features"`
+	Pools     []Pool   `yaml:"pools"`
+}
+
+// Provider holds the IaaS settings.
+type Provider struct {
+	Name               string `yaml:"name"`
+	Region             string `yaml:"region"`
+	Zone               string `yaml:"zone"`
+	KeyPair            string `yaml:"keyPair"`
+	CoreOSChannel      string `yaml:"coreOSChannel"`
+	VpcCidrBlock       string `yaml:"vpcCidrBlock"`
+	InternalSubnetCidr string `yaml:"internalSubnetCidr"`
+	ExternalSubnetCidr string `yaml:"externalSubnetCidr"`
+}
+
+// DNS holds the DNS provider settings.
+type DNS struct {
+	Provider string `yaml:"provider"`
+	APIKey   string `yaml:"apiKey"`
+}
+
+// Alerting holds the notification settings.
+type Alerting struct {
+	SlackWebhook string `yaml:"slackWebhook"`
+	SMTPURL      string `yaml:"smtpURL"`
+	AdminEmail   string `yaml:"adminEmail"`
+}
+
+// Features holds optional cluster features.
+type Features struct {
+	CaCertPath   string   `yaml:"caCertPath"`
+	CalicoIPPool string   `yaml:"calicoIPPool"`
+	EtcdToken    string   `yaml:"etcdToken"`
+	StubZones    []string `yaml:"stubZones"`
+}
+
+// Pool is a group of identical nodes.
+type Pool struct {
+	HostName     string   `yaml:"hostName"`
+	Count        int      `yaml:"count"`
+	InstanceType string   `yaml:"instanceType"`
+	Roles        []string `yaml:"roles"`
+}
+
+//-----------------------------------------------------------------------------
+// func: defaults
+//-----------------------------------------------------------------------------
+
+// defaults mirrors the defaults of the 'ec2 deploy' flags.
+func (s *Spec) defaults() {
+
+	if s.Provider.Zone == "" {
+		s.Provider.Zone = "a"
+	}
+
+	if s.Provider.CoreOSChannel == "" {
+		s.Provider.CoreOSChannel = "stable"
+	}
+
+	if s.Provider.VpcCidrBlock == "" {
+		s.Provider.VpcCidrBlock = "10.0.0.0/16"
+	}
+
+	if s.Provider.ExternalSubnetCidr == "" {
+		s.Provider.ExternalSubnetCidr = "10.0.0.0/24"
+	}
+
+	if s.DNS.Provider == "" {
+		s.DNS.Provider = "r53"
+	}
+
+	if s.Features.CalicoIPPool == "" {
+		s.Features.CalicoIPPool = "10.128.0.0/21"
+	}
+
+	if s.Features.EtcdToken == "" {
+		s.Features.EtcdToken = "auto"
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: validate
+//-----------------------------------------------------------------------------
+
+func (s *Spec) validate() error {
+
+	if s.Version != "v1" {
+		return errors.New("Unsupported spec version: " + s.Version)
+	}
+
+	if match, _ := regexp.MatchString("^[a-zA-Z0-9-]+$", s.ClusterID); !match {
+		return errors.New("Invalid clusterID: " + s.ClusterID)
+	}
+
+	if s.Domain == "" {
+		return errors.New("Missing domain")
+	}
+
+	if s.Provider.Name != "ec2" {
+		return errors.New("Unsupported provider: " + s.Provider.Name)
+	}
+
+	if !oneOf(s.Provider.Region, cli.Ec2Regions) {
+		return errors.New("Invalid region: " + s.Provider.Region)
+	}
+
+	if !oneOf(s.Provider.Zone, ec2.Ec2Zones) {
+		return errors.New("Invalid zone: " + s.Provider.Zone)
+	}
+
+	if s.Provider.KeyPair == "" {
+		return errors.New("Missing provider keyPair")
+	}
+
+	if !oneOf(s.Provider.CoreOSChannel, []string{"stable", "beta", "alpha"}) {
+		return errors.New("Invalid coreOSChannel: " + s.Provider.CoreOSChannel)
+	}
+
+	if !oneOf(s.DNS.Provider, []string{"none", "ns1", "r53", "rfc2136"}) {
+		return errors.New("Invalid DNS provider: " + s.DNS.Provider)
+	}
+
+	if s.Alerting.SMTPURL != "" {
+		if match, _ := regexp.MatchString("^smtp://(.+):(.+)@(.+):(\\d+)$", s.Alerting.SMTPURL); !match {
+			return errors.New("Invalid smtpURL: " + s.Alerting.SMTPURL)
+		}
+	}
+
+	if s.Alerting.AdminEmail != "" {
+		if match, _ := regexp.MatchString("^[\\w-.+]+@[\\w-.+]+\\.[a-z]{2,4}$", s.Alerting.AdminEmail); !match {
+			return errors.New("Invalid adminEmail: " + s.Alerting.AdminEmail)
+		}
+	}
+
+	// Validate the node pools:
+	names := map[string]bool{}
+	for _, p := range s.Pools {
+
+		if match, _ := regexp.MatchString("^[a-z\\d-]+$", p.HostName); !match {
+			return errors.New("Invalid pool hostName: " + p.HostName)
+		}
+
+		if names[p.HostName] {
+			return errors.New("Duplicated pool hostName: " + p.HostName)
+		}
+		names[p.HostName] = true
+
+		if p.Count < 0 {
+			return errors.New("Negative count in pool " + p.HostName)
+		}
+
+		if !oneOf(p.InstanceType, cli.Ec2Instances) {
+			return errors.New("Invalid instanceType in pool " + p.HostName)
+		}
+
+		if len(p.Roles) == 0 {
+			return errors.New("Missing roles in pool " + p.HostName)
+		}
+
+		for _, role := range p.Roles {
+			if _, ok := kato.GetRole(role); !ok {
+				return errors.New("Unknown role " + role + " in pool " + p.HostName)
+			}
+		}
+	}
+
+	// Odd sized quorum:
+	if q := kato.CountNodes(s.quadruplets(), "quorum"); !oneOf(strconv.Itoa(q), []string{"1", "3", "5"}) {
+		return errors.New("The quorum must have 1, 3 or 5 nodes")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: quadruplets
+//-----------------------------------------------------------------------------
+
+// quadruplets translates the node pools into 'ec2 deploy' quadruplets.
+func (s *Spec) quadruplets() (quads []string) {
+	for _, p := range s.Pools {
+		quads = append(quads, strconv.Itoa(p.Count)+":"+p.InstanceType+":"+
+			p.HostName+":"+strings.Join(p.Roles, ","))
+	}
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: oneOf
+//-----------------------------------------------------------------------------
+
+func oneOf(s string, list []string) bool {
+	for _, i := range list {
+		if s == i {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: Apply
+//-----------------------------------------------------------------------------
+
+// Apply validates the cluster spec and converges the cluster towards it.
+func (d *Data) Apply() {
+
+	// Set current command:
+	d.command = "apply"
+
+	// Read and decode the spec (JSON is valid YAML):
+	raw, err := ioutil.ReadFile(d.File)
+	if err != nil {
+		log.WithField("cmd", d.command).Fatal(err)
+	}
+
+	if err := yaml.Unmarshal(raw, &d.Spec); err != nil {
+		log.WithFields(log.Fields{"cmd": d.command, "file": d.File}).Fatal(err)
+	}
+
+	// Validate the spec:
+	d.defaults()
+	if err := d.validate(); err != nil {
+		log.WithFields(log.Fields{"cmd": d.command, "file": d.File}).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": d.command, "id": d.ClusterID}).
+		Info("Applying the cluster spec")
+
+	// Hand over to the provider:
+	switch d.Provider.Name {
+	case "ec2":
+		e := ec2.Data{
+			State: ec2.State{
+				ClusterID:     d.ClusterID,
+				Domain:        d.Domain,
+				Region:        d.Provider.Region,
+				Zone:          d.Provider.Zone,
+				KeyPair:       d.Provider.KeyPair,
+				CoreOSChannel: d.Provider.CoreOSChannel,
+				VpcCidrBlock:  d.Provider.VpcCidrBlock,
+				IntSubnetCidr: d.Provider.InternalSubnetCidr,
+				ExtSubnetCidr: d.Provider.ExternalSubnetCidr,
+				DNSProvider:   d.DNS.Provider,
+				DNSApiKey:     d.DNS.APIKey,
+				SlackWebhook:  d.Alerting.SlackWebhook,
+				SMTPURL:       d.Alerting.SMTPURL,
+				AdminEmail:    d.Alerting.AdminEmail,
+				CaCertPath:    d.Features.CaCertPath,
+				CalicoIPPool:  d.Features.CalicoIPPool,
+				EtcdToken:     d.Features.EtcdToken,
+				StubZones:     d.Features.StubZones,
+				Quadruplets:   d.quadruplets(),
+			},
+		}
+		e.Apply()
+	}
+}
@@ -0,0 +1,105 @@
+package apply
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// func: validSpec
+//-----------------------------------------------------------------------------
+
+// validSpec returns a spec which passes the validation once defaulted.
+func validSpec() Spec {
+	s := Spec{
+		Version:   "v1",
+		ClusterID: "my-cluster",
+		Domain:    "cell-1.dc-1.demo.lan",
+		Provider:  Provider{Name: "ec2", Region: "eu-west-1", KeyPair: "my-key"},
+		Pools: []Pool{
+			{HostName: "quorum", Count: 3, InstanceType: "m3.medium", Roles: []string{"quorum"}},
+			{HostName: "worker", Count: 2, InstanceType: "m3.large", Roles: []string{"worker", "border"}},
+		},
+	}
+	s.defaults()
+	return s
+}
+
+//-----------------------------------------------------------------------------
+// func: TestValidate
+//-----------------------------------------------------------------------------
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(s *Spec)
+		err    string // Expected error substring, empty when valid
+	}{
+		{"valid", func(s *Spec) {}, ""},
+		{"version", func(s *Spec) { s.Version = "v2" }, "Unsupported spec version"},
+		{"clusterID", func(s *Spec) { s.ClusterID = "my_cluster" }, "Invalid clusterID"},
+		{"domain", func(s *Spec) { s.Domain = "" }, "Missing domain"},
+		{"provider", func(s *Spec) { s.Provider.Name = "pkt" }, "Unsupported provider"},
+		{"region", func(s *Spec) { s.Provider.Region = "mars-1" }, "Invalid region"},
+		{"zone", func(s *Spec) { s.Provider.Zone = "z" }, "Invalid zone"},
+		{"keyPair", func(s *Spec) { s.Provider.KeyPair = "" }, "Missing provider keyPair"},
+		{"channel", func(s *Spec) { s.Provider.CoreOSChannel = "edge" }, "Invalid coreOSChannel"},
+		{"dns provider", func(s *Spec) { s.DNS.Provider = "bind" }, "Invalid DNS provider"},
+		{"rfc2136", func(s *Spec) { s.DNS.Provider = "rfc2136" }, ""},
+		{"smtpURL", func(s *Spec) { s.Alerting.SMTPURL = "smtp://mail:25" }, "Invalid smtpURL"},
+		{"smtpURL ok", func(s *Spec) { s.Alerting.SMTPURL = "smtp://u:p@mail:25" }, ""},
+		{"adminEmail", func(s *Spec) { s.Alerting.AdminEmail = "admin" }, "Invalid adminEmail"},
+		{"pool hostName", func(s *Spec) { s.Pools[1].HostName = "Worker" }, "Invalid pool hostName"},
+		{"duplicated pool", func(s *Spec) { s.Pools[1].HostName = "quorum" }, "Duplicated pool hostName"},
+		{"negative count", func(s *Spec) { s.Pools[1].Count = -1 }, "Negative count"},
+		{"instanceType", func(s *Spec) { s.Pools[1].InstanceType = "x9.huge" }, "Invalid instanceType"},
+		{"no roles", func(s *Spec) { s.Pools[1].Roles = nil }, "Missing roles"},
+		{"unknown role", func(s *Spec) { s.Pools[1].Roles = []string{"ghost"} }, "Unknown role ghost"},
+		{"even quorum", func(s *Spec) { s.Pools[0].Count = 2 }, "1, 3 or 5 nodes"},
+		{"no quorum", func(s *Spec) { s.Pools = s.Pools[1:] }, "1, 3 or 5 nodes"},
+	}
+
+	for _, tt := range tests {
+		s := validSpec()
+		tt.change(&s)
+		err := s.validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: expected an error", tt.name)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: got %q, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestQuadruplets
+//-----------------------------------------------------------------------------
+
+func TestQuadruplets(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		pools []Pool
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"one role", []Pool{{HostName: "quorum", Count: 3, InstanceType: "m3.medium",
+			Roles: []string{"quorum"}}}, []string{"3:m3.medium:quorum:quorum"}},
+		{"roles in order", []Pool{
+			{HostName: "worker", Count: 0, InstanceType: "m3.large", Roles: []string{"worker", "border"}},
+			{HostName: "master", Count: 1, InstanceType: "m3.medium", Roles: []string{"master"}},
+		}, []string{"0:m3.large:worker:worker,border", "1:m3.medium:master:master"}},
+	}
+
+	for _, tt := range tests {
+		s := Spec{Pools: tt.pools}
+		if got := s.quadruplets(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
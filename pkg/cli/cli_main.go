@@ -14,6 +14,9 @@ import (
 	// Community:
 	log "github.com/Sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
+
+	// Local:
+	"github.com/katosys/kato/pkg/kato"
 )
 
 //-----------------------------------------------------------------------------
@@ -26,7 +29,20 @@ var (
 	App = kingpin.New("katoctl", "Katoctl defines and deploys Kato's infrastructure.")
 
 	// KatoRoles is a slice of valid Káto roles:
-	KatoRoles = []string{"quorum", "master", "worker", "border"}
+	KatoRoles []string
+
+	// Ec2Instances is a slice of EC2 instances types:
+	Ec2Instances = []string{
+		"c3.2xlarge", "c3.4xlarge", "c3.8xlarge", "c3.large", "c3.xlarge", "cc2.8xlarge",
+		"cg1.4xlarge", "d2.2xlarge", "d2.4xlarge", "d2.8xlarge", "d2.xlarge", "g2.2xlarge",
+		"g2.8xlarge", "hi1.4xlarge", "hs1.8xlarge", "i2.2xlarge", "i2.4xlarge", "i2.8xlarge",
+		"i2.xlarge", "m3.2xlarge", "m3.large", "m3.medium", "m3.xlarge", "r3.2xlarge",
+		"r3.4xlarge", "r3.8xlarge", "r3.large", "r3.xlarge", "x1.32xlarge"}
+
+	// Ec2Regions is a slice of EC2 regions:
+	Ec2Regions = []string{
+		"us-east-1", "us-west-1", "us-west-2", "eu-west-1", "eu-central-1", "ap-northeast-1",
+		"ap-northeast-2", "ap-southeast-1", "ap-southeast-2", "sa-east-1"}
 )
 
 //----------------------------------------------------------------------------
@@ -41,6 +57,12 @@ func init() {
 	App.Version("v0.1.2").Author("Marc Villacorta Morera")
 	App.UsageTemplate(usageTemplate)
 	App.HelpFlag.Short('h')
+
+	// Load the role registry:
+	if err := kato.LoadRoles(); err != nil {
+		log.WithField("cmd", "cli").Fatal(err)
+	}
+	KatoRoles = kato.RoleNames()
 }
 
 //-----------------------------------------------------------------------------
@@ -154,3 +176,44 @@ func Quadruplets(s kingpin.Settings, types, roles []string) *[]string {
 	s.SetValue(target)
 	return &target.quadList
 }
+
+//-----------------------------------------------------------------------------
+// Roles list custom parser:
+//-----------------------------------------------------------------------------
+
+type rolesValue struct {
+	value string
+	roles []string
+}
+
+func (r *rolesValue) Set(value string) error {
+
+	for _, role := range strings.Split(value, ",") {
+		found := false
+		for _, name := range r.roles {
+			if name == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.WithField("value", value).
+				Fatal("Unknown role " + role + ", valid roles: " + strings.Join(r.roles, ", "))
+		}
+	}
+
+	r.value = value
+	return nil
+}
+
+func (r *rolesValue) String() string {
+	return r.value
+}
+
+// Roles is a comma separated list of registered roles custom parser.
+func Roles(s kingpin.Settings, roles []string) *string {
+	target := &rolesValue{}
+	target.roles = roles
+	s.SetValue(target)
+	return &target.value
+}
@@ -0,0 +1,160 @@
+package cluster
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// func: createDNSZones
+//-----------------------------------------------------------------------------
+
+// createDNSZones creates the <domain> and (int|ext).<domain> zones. The int
+// zone is left to createPrivateDNSZone when it can be private. The zones
+// which did not exist before are returned in created.
+func createDNSZones(wch *kato.WaitChan, p Provider, c *Cluster, created *[]string) {
+
+	// Decrement:
+	defer wch.WaitGrp.Done()
+
+	d, err := dns.New(c.DNSProvider, c.DNSApiKey)
+	if err != nil {
+		wch.ErrChan <- err
+		return
+	}
+
+	zones := []string{c.Domain, "ext." + c.Domain}
+	if _, ok := privateZoner(p, d); !ok {
+		zones = append(zones, "int."+c.Domain)
+	}
+
+	// Existing zones are kept and not owned by the cluster:
+	existing, err := d.ListZones()
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": p.Name() + ":deploy", "id": c.Domain}).Error(err)
+		wch.ErrChan <- err
+		return
+	}
+
+	if err := d.CreateZones(zones...); err != nil {
+		log.WithFields(log.Fields{"cmd": p.Name() + ":deploy", "id": c.Domain}).Error(err)
+		wch.ErrChan <- err
+		return
+	}
+
+	*created = newZones(zones, existing)
+}
+
+//-----------------------------------------------------------------------------
+// func: createPrivateDNSZone
+//-----------------------------------------------------------------------------
+
+// createPrivateDNSZone creates the int.<domain> zone bound to the private
+// network of the provider, so internal names only resolve from inside it.
+// The zone is returned, it belongs to the network of the cluster.
+func createPrivateDNSZone(p Provider, c *Cluster) ([]string, error) {
+
+	d, err := dns.New(c.DNSProvider, c.DNSApiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pz, ok := privateZoner(p, d)
+	if !ok {
+		return nil, nil
+	}
+
+	id, region := p.(PrivateNetworker).PrivateNetwork()
+	if err := pz.CreatePrivateZones(id, region, "int."+c.Domain); err != nil {
+		log.WithFields(log.Fields{"cmd": p.Name() + ":deploy", "id": "int." + c.Domain}).Error(err)
+		return nil, err
+	}
+
+	return []string{"int." + c.Domain}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: newZones
+//-----------------------------------------------------------------------------
+
+// newZones returns the zones not found in existing, trailing dots aside.
+func newZones(zones, existing []string) []string {
+
+	found := map[string]bool{}
+	for _, z := range existing {
+		found[strings.TrimSuffix(z, ".")] = true
+	}
+
+	created := []string{}
+	for _, z := range zones {
+		if !found[strings.TrimSuffix(z, ".")] {
+			created = append(created, z)
+		}
+	}
+
+	return created
+}
+
+//-----------------------------------------------------------------------------
+// func: privateZoner
+//-----------------------------------------------------------------------------
+
+// privateZoner returns d as a dns.PrivateZoner if p has a private network.
+func privateZoner(p Provider, d dns.Provider) (dns.PrivateZoner, bool) {
+
+	if _, ok := p.(PrivateNetworker); !ok {
+		return nil, false
+	}
+
+	pz, ok := d.(dns.PrivateZoner)
+	return pz, ok
+}
+
+//-----------------------------------------------------------------------------
+// func: PublishDNSRecords
+//-----------------------------------------------------------------------------
+
+// PublishDNSRecords publishes the internal, external and CNAME records of
+// every role of the node.
+func PublishDNSRecords(c *Cluster, n *Node, ips *IPs) error {
+
+	d, err := dns.New(c.DNSProvider, c.DNSApiKey)
+	if err != nil {
+		return err
+	}
+
+	// For every role in this node:
+	for _, role := range n.Roles {
+
+		name := role + "-" + n.HostID
+
+		// Internal record:
+		if err := d.CreateRecords("int."+c.Domain, name+":A:"+ips.Internal); err != nil {
+			return err
+		}
+
+		// External record:
+		if err := d.CreateRecords("ext."+c.Domain, name+":A:"+ips.External); err != nil {
+			return err
+		}
+
+		// CNAME record:
+		if err := d.CreateRecords(c.Domain, name+":CNAME:"+name+".int."+c.Domain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
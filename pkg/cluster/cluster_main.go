@@ -0,0 +1,345 @@
+package cluster
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/kato"
+	"github.com/katosys/kato/pkg/udata"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Provider is implemented by every IaaS provider. The primitives know nothing
+// about quadruplets, user data or DNS, that is what the orchestrator is for.
+type Provider interface {
+	Name() string                            // ec2 | pkt (also the udata IaaS provider)
+	SetupNetwork() error                     // Networks, gateways and instance identities
+	SetupFirewall() error                    // Per role firewall rules
+	SetupBalancer() error                    // Load balancer in front of the ELB roles
+	Udata(flags *udata.CmdFlags)             // Provider specific user data flags
+	RunInstance(n *Node, udata []byte) error // Launches n and sets its ID
+	TerminateInstance(n *Node) error         // Terminates n and its leftovers
+	ListInstances() ([]*Node, error)         // Pending and running cluster nodes
+	InstanceIPs(n *Node) (*IPs, error)       // Waits for the addresses of n
+	AttachBalancer(n *Node) error            // Puts n behind the load balancer
+	SaveState(c *Cluster) error              // Dumps the state merged with c
+}
+
+// PrivateNetworker is implemented by the providers whose nodes share a
+// private network, where the int.<domain> zone is hosted when the DNS
+// provider can.
+type PrivateNetworker interface {
+	PrivateNetwork() (id, region string) // Known once the network is set up
+}
+
+// Cluster holds the provider agnostic cluster data.
+type Cluster struct {
+	ClusterID    string
+	Domain       string
+	Quadruplets  []string
+	QuorumCount  int
+	MasterCount  int
+	EtcdToken    string
+	DNSProvider  string
+	DNSApiKey    string
+	CalicoIPPool string
+	CaCertPath   string
+	StubZones    []string
+	SlackWebhook string
+	SMTPURL      string
+	AdminEmail   string
+	SecretsURL   string
+	DNSZones     []string // Zones created by Deploy, the only ones destroyed
+}
+
+// Node is a cluster node.
+type Node struct {
+	ID           string   // Provider instance ID
+	HostName     string   // quorum | master | worker | ...
+	HostID       string   // 1 | 2 | 3 | ...
+	Roles        []string // Kato roles
+	InstanceType string   // Provider instance type or plan
+	ClusterState string   // new | existing
+}
+
+// IPs holds the addresses of a node.
+type IPs struct {
+	Internal string `json:"internal"`
+	External string `json:"external"`
+}
+
+//-----------------------------------------------------------------------------
+// func: FQDN
+//-----------------------------------------------------------------------------
+
+// FQDN returns the <host-name>-<host-id>.<domain> node name.
+func (n *Node) FQDN(domain string) string {
+	return n.HostName + "-" + n.HostID + "." + domain
+}
+
+//-----------------------------------------------------------------------------
+// func: Setup
+//-----------------------------------------------------------------------------
+
+// Setup creates the network, then the firewall and the load balancer.
+func Setup(p Provider) error {
+
+	// Network first:
+	if err := p.SetupNetwork(); err != nil {
+		return err
+	}
+
+	// Firewall and load balancer:
+	wch := kato.NewWaitChan(2)
+	for _, setup := range []func() error{p.SetupFirewall, p.SetupBalancer} {
+		go func(setup func() error) {
+			defer wch.WaitGrp.Done()
+			if err := setup(); err != nil {
+				wch.ErrChan <- err
+			}
+		}(setup)
+	}
+
+	return wch.WaitErr()
+}
+
+//-----------------------------------------------------------------------------
+// func: Deploy
+//-----------------------------------------------------------------------------
+
+// Deploy sets up the provider, the DNS zones and the etcd token, saves the
+// state and starts the nodes described by the quadruplets.
+func Deploy(p Provider, c *Cluster) error {
+
+	// Count quorum and master nodes:
+	c.QuorumCount = kato.CountNodes(c.Quadruplets, "quorum")
+	c.MasterCount = kato.CountNodes(c.Quadruplets, "master")
+
+	// Setup the environment (I):
+	var public, private []string
+	wch := kato.NewWaitChan(3)
+	go func() {
+		defer wch.WaitGrp.Done()
+		log.WithFields(log.Fields{"cmd": p.Name() + ":deploy", "id": c.Domain}).
+			Info("Setup the " + p.Name() + " environment")
+		if err := Setup(p); err != nil {
+			wch.ErrChan <- err
+			return
+		}
+		var err error
+		if private, err = createPrivateDNSZone(p, c); err != nil {
+			wch.ErrChan <- err
+		}
+	}()
+	go createDNSZones(wch, p, c, &public)
+	go kato.NewEtcdToken(wch, c.QuorumCount, &c.EtcdToken)
+
+	// Wait and check for errors:
+	if err := wch.WaitErr(); err != nil {
+		return err
+	}
+
+	c.DNSZones = append(public, private...)
+
+	// Dump state to file (II):
+	if err := p.SaveState(c); err != nil {
+		return err
+	}
+
+	// Deploy all the nodes (III):
+	_, err := Converge(p, c, "new")
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: Converge
+//-----------------------------------------------------------------------------
+
+// Converge adds the nodes described by the quadruplets which are not running
+// yet. The running nodes not described by them are returned. A failed node
+// doesn't stop the others, all the failures are reported once every node is
+// done.
+func Converge(p Provider, c *Cluster, clusterState string) ([]*Node, error) {
+
+	// Find the nodes already running:
+	running, err := p.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	extra := map[string]*Node{}
+	for _, n := range running {
+		extra[n.FQDN(c.Domain)] = n
+	}
+
+	// Add the missing nodes, every failure is collected:
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := []string{}
+
+	for _, q := range c.Quadruplets {
+
+		s := strings.Split(q, ":")
+		count, _ := strconv.Atoi(s[0])
+
+		log.WithField("cmd", p.Name()+":deploy").
+			Info("Deploying " + s[0] + " " + s[2] + " nodes")
+
+		for i := 1; i <= count; i++ {
+
+			n := &Node{
+				HostName:     s[2],
+				HostID:       strconv.Itoa(i),
+				Roles:        strings.Split(s[3], ","),
+				InstanceType: s[1],
+				ClusterState: clusterState,
+			}
+
+			if _, ok := extra[n.FQDN(c.Domain)]; ok {
+				delete(extra, n.FQDN(c.Domain))
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := AddNode(p, c, n); err != nil {
+					mu.Lock()
+					failed = append(failed, n.FQDN(c.Domain)+": "+err.Error())
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+
+	// Wait for all the nodes, even after a failure:
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return nil, errors.New(strconv.Itoa(len(failed)) + " nodes failed: " +
+			strings.Join(failed, "; "))
+	}
+
+	nodes := []*Node{}
+	for _, n := range extra {
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: AddNode
+//-----------------------------------------------------------------------------
+
+// AddNode launches a node and publishes its DNS records.
+func AddNode(p Provider, c *Cluster, n *Node) error {
+
+	ips, err := LaunchNode(p, c, n)
+	if err != nil {
+		return err
+	}
+
+	// Publish DNS records:
+	if err := PublishDNSRecords(c, n, ips); err != nil {
+		log.WithFields(log.Fields{"cmd": p.Name() + ":add", "id": n.FQDN(c.Domain)}).
+			Warning(err)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: LaunchNode
+//-----------------------------------------------------------------------------
+
+// LaunchNode runs the instance of a node, registers it with the balancer and
+// returns its addresses. No DNS record is published.
+func LaunchNode(p Provider, c *Cluster, n *Node) (*IPs, error) {
+
+	// Render the user data:
+	data, err := c.udata(p, n).Render()
+	if err != nil {
+		return nil, err
+	}
+
+	// Launch the instance:
+	if err := p.RunInstance(n, data); err != nil {
+		return nil, err
+	}
+
+	// Register with the load balancer:
+	for _, name := range n.Roles {
+		if role, ok := kato.GetRole(name); ok && role.ELB {
+			if err := p.AttachBalancer(n); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// Retrieve the instance IPs:
+	return p.InstanceIPs(n)
+}
+
+//-----------------------------------------------------------------------------
+// func: udata
+//-----------------------------------------------------------------------------
+
+// udata forges the user data of a node, the provider adds its own flags.
+func (c *Cluster) udata(p Provider, n *Node) *udata.CmdData {
+
+	// The udata flags are not parsed, honor their environment:
+	outputFormat := os.Getenv("KATO_UDATA_OUTPUT_FORMAT")
+	if outputFormat == "" {
+		outputFormat = "ignition"
+	}
+
+	u := &udata.CmdData{
+		CmdFlags: udata.CmdFlags{
+			AdminEmail:      c.AdminEmail,
+			CaCertPath:      c.CaCertPath,
+			CalicoIPPool:    c.CalicoIPPool,
+			ClusterID:       c.ClusterID,
+			ClusterState:    n.ClusterState,
+			Domain:          c.Domain,
+			EtcdToken:       c.EtcdToken,
+			FragmentsDir:    os.Getenv("KATO_UDATA_FRAGMENTS_DIR"),
+			HostID:          n.HostID,
+			HostName:        n.HostName,
+			IaasProvider:    p.Name(),
+			MasterCount:     c.MasterCount,
+			OutputFormat:    outputFormat,
+			DNSProvider:     c.DNSProvider,
+			DNSApiKey:       c.DNSApiKey,
+			Prometheus:      true,
+			QuorumCount:     c.QuorumCount,
+			Roles:           n.Roles,
+			SecretsURL:      c.SecretsURL,
+			ServicesCatalog: os.Getenv("KATO_UDATA_SERVICES_CATALOG"),
+			SlackWebhook:    c.SlackWebhook,
+			SMTPURL:         c.SMTPURL,
+			StubZones:       c.StubZones,
+		},
+	}
+
+	p.Udata(&u.CmdFlags)
+	return u
+}
@@ -0,0 +1,157 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+	"github.com/katosys/kato/pkg/cli"
+)
+
+//-----------------------------------------------------------------------------
+// 'katoctl dns' command flags definitions:
+//-----------------------------------------------------------------------------
+
+var (
+
+	// dns zone/record:
+	cmdDNS = cli.App.Command("dns",
+		"Manages zones and records of any DNS provider.")
+
+	flDNSProvider = cmdDNS.Flag("provider",
+		"DNS provider [ none | ns1 | r53 | rfc2136 ]").
+		Default("none").PlaceHolder("KATO_DNS_PROVIDER").
+		OverrideDefaultFromEnvar("KATO_DNS_PROVIDER").
+		String()
+
+	flDNSApiKey = cmdDNS.Flag("api-key",
+		"DNS private API key.").
+		PlaceHolder("KATO_DNS_API_KEY").
+		OverrideDefaultFromEnvar("KATO_DNS_API_KEY").
+		String()
+
+	cmdDNSZone   = cmdDNS.Command("zone", "Manage DNS zones.")
+	cmdDNSRecord = cmdDNS.Command("record", "Manage DNS records.")
+
+	// dns zone add:
+	cmdDNSZoneAdd    = cmdDNSZone.Command("add", "Adds DNS zones.")
+	arDNSZoneAddName = cmdDNSZoneAdd.Arg("fqdn",
+		"List of zones to publish.").Required().Strings()
+	flDNSZoneAddParent = cmdDNSZoneAdd.Flag("parent-provider",
+		"Delegate from a parent zone of this provider [ ns1 | r53 | rfc2136 ]").String()
+	flDNSZoneAddParentKey = cmdDNSZoneAdd.Flag("parent-api-key",
+		"API key of the parent provider.").String()
+
+	// dns zone del:
+	cmdDNSZoneDel    = cmdDNSZone.Command("del", "Deletes DNS zones.")
+	arDNSZoneDelName = cmdDNSZoneDel.Arg("fqdn",
+		"List of zones to delete.").Required().Strings()
+	flDNSZoneDelRecursive = cmdDNSZoneDel.Flag("recursive",
+		"Purge records, child zones and the parent delegation first.").Bool()
+	flDNSZoneDelParent = cmdDNSZoneDel.Flag("parent-provider",
+		"Remove the delegation from this provider [ ns1 | r53 | rfc2136 ]").String()
+	flDNSZoneDelParentKey = cmdDNSZoneDel.Flag("parent-api-key",
+		"API key of the parent provider.").String()
+
+	// dns record add:
+	cmdDNSRecordAdd    = cmdDNSRecord.Command("add", "Adds records to DNS zones.")
+	flDNSRecordAddZone = cmdDNSRecordAdd.Flag("zone",
+		"DNS zone where records are added.").Required().String()
+	arDNSRecordAddName = cmdDNSRecordAdd.Arg("record",
+		"List of 'name [ttl] type data', JSON or name:type:data records.").
+		Required().Strings()
+
+	// dns record del:
+	cmdDNSRecordDel    = cmdDNSRecord.Command("del", "Deletes records from DNS zones.")
+	flDNSRecordDelZone = cmdDNSRecordDel.Flag("zone",
+		"DNS zone where records are deleted.").Required().String()
+	arDNSRecordDelName = cmdDNSRecordDel.Arg("record",
+		"List of name:type records.").Required().Strings()
+
+	// dns record list:
+	cmdDNSRecordList = cmdDNSRecord.Command("list",
+		"Lists records of DNS zones, exits 2 if none matches.")
+	flDNSRecordListZone = cmdDNSRecordList.Flag("zone",
+		"DNS zone where records are listed.").Required().String()
+	flDNSRecordListName = cmdDNSRecordList.Flag("name",
+		"Only records whose name matches this glob.").String()
+	flDNSRecordListType = cmdDNSRecordList.Flag("type",
+		"Only records of this type.").String()
+	flDNSRecordListOutput = cmdDNSRecordList.Flag("output",
+		"Output format [ text | json ]").
+		Default("text").Enum("text", "json")
+)
+
+//-----------------------------------------------------------------------------
+// RunCmd:
+//-----------------------------------------------------------------------------
+
+// RunCmd runs the cmd if owned by this package.
+func RunCmd(cmd string) bool {
+
+	switch cmd {
+
+	// katoctl dns zone add:
+	case cmdDNSZoneAdd.FullCommand():
+		d := Data{
+			Provider: *flDNSProvider,
+			APIKey:   *flDNSApiKey,
+			Zones:    *arDNSZoneAddName,
+
+			ParentProvider: *flDNSZoneAddParent,
+			ParentAPIKey:   *flDNSZoneAddParentKey,
+		}
+		d.AddZones()
+
+	// katoctl dns zone del:
+	case cmdDNSZoneDel.FullCommand():
+		d := Data{
+			Provider: *flDNSProvider,
+			APIKey:   *flDNSApiKey,
+			Zones:    *arDNSZoneDelName,
+
+			Recursive:      *flDNSZoneDelRecursive,
+			ParentProvider: *flDNSZoneDelParent,
+			ParentAPIKey:   *flDNSZoneDelParentKey,
+		}
+		d.DelZones()
+
+	// katoctl dns record add:
+	case cmdDNSRecordAdd.FullCommand():
+		d := Data{
+			Provider: *flDNSProvider,
+			APIKey:   *flDNSApiKey,
+			Zone:     *flDNSRecordAddZone,
+			Records:  *arDNSRecordAddName,
+		}
+		d.AddRecords()
+
+	// katoctl dns record del:
+	case cmdDNSRecordDel.FullCommand():
+		d := Data{
+			Provider: *flDNSProvider,
+			APIKey:   *flDNSApiKey,
+			Zone:     *flDNSRecordDelZone,
+			Records:  *arDNSRecordDelName,
+		}
+		d.DelRecords()
+
+	// katoctl dns record list:
+	case cmdDNSRecordList.FullCommand():
+		d := Data{
+			Provider: *flDNSProvider,
+			APIKey:   *flDNSApiKey,
+			Zone:     *flDNSRecordListZone,
+			Name:     *flDNSRecordListName,
+			Type:     *flDNSRecordListType,
+			Output:   *flDNSRecordListOutput,
+		}
+		d.ShowRecords()
+
+	// Nothing to do:
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,264 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Provider is implemented by every DNS provider. Records to create are specs
+// parsed by ParseRecords, records to delete are name:type pairs and zone names
+// may or may not end with a dot.
+type Provider interface {
+	CreateZones(zones ...string) error                  // Existing zones are kept
+	DeleteZones(zones ...string) error                  // Zones and their records
+	ListZones() ([]string, error)                       // All the public zones of the account
+	NameServers(zone string) ([]string, error)          // Authoritative servers of zone
+	CreateRecords(zone string, records ...string) error // Upsert record specs
+	DeleteRecords(zone string, records ...string) error // Delete name:type
+	ListRecords(zone string) ([]Record, error)          // All the records of zone
+}
+
+// PrivateZoner is implemented by the providers able to host zones which only
+// resolve from inside a private network such as an EC2 VPC.
+type PrivateZoner interface {
+	CreatePrivateZones(network, region string, zones ...string) error
+}
+
+// Record is a resource record set as listed by a provider.
+type Record struct {
+	Name string   `json:"name"` // Relative to the zone, @ for the apex
+	Type string   `json:"type"`
+	TTL  int      `json:"ttl"`
+	Data []string `json:"data"`
+}
+
+// Factory returns a provider authenticated with the given API key.
+type Factory func(apiKey string) Provider
+
+// Data struct for the provider agnostic 'katoctl dns' command.
+type Data struct {
+	command  string
+	Provider string
+	APIKey   string
+	Zone     string
+	Zones    []string
+	Records  []string
+	Name     string
+	Type     string
+	Output   string
+
+	ParentProvider string // Delegate from a parent zone of this provider
+	ParentAPIKey   string // API key of the parent provider
+	Recursive      bool   // Purge records and child zones on deletion
+}
+
+//-----------------------------------------------------------------------------
+// Provider registry:
+//-----------------------------------------------------------------------------
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		"none": func(string) Provider { return none{} },
+	}
+)
+
+//-----------------------------------------------------------------------------
+// func: Register
+//-----------------------------------------------------------------------------
+
+// Register makes a provider available by the name used in --dns-provider.
+// Providers register themselves when their package is initialized.
+func Register(name string, factory Factory) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, dup := factories[name]; dup {
+		panic("DNS provider registered twice: " + name)
+	}
+
+	factories[name] = factory
+}
+
+//-----------------------------------------------------------------------------
+// func: New
+//-----------------------------------------------------------------------------
+
+// New returns the named provider.
+func New(name, apiKey string) (Provider, error) {
+
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Unsupported DNS provider: " + name)
+	}
+
+	return factory(apiKey), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Names
+//-----------------------------------------------------------------------------
+
+// Names returns the sorted names of the registered providers.
+func Names() (names []string) {
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for name := range factories {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: RelativeName
+//-----------------------------------------------------------------------------
+
+// RelativeName returns the name of fqdn relative to zone, @ for the apex.
+func RelativeName(fqdn, zone string) string {
+
+	fqdn, zone = strings.TrimSuffix(fqdn, "."), strings.TrimSuffix(zone, ".")
+	if fqdn == zone {
+		return "@"
+	}
+
+	return strings.TrimSuffix(fqdn, "."+zone)
+}
+
+//-----------------------------------------------------------------------------
+// func: AddZones
+//-----------------------------------------------------------------------------
+
+// AddZones adds one or more zones to the provider. The zones without a parent
+// in the provider are delegated from the parent provider, if any.
+func (d *Data) AddZones() {
+
+	d.command = "zone:add"
+	p := d.provider()
+
+	if err := p.CreateZones(d.Zones...); err != nil {
+		log.WithField("cmd", "dns:"+d.command).Fatal(err)
+	}
+
+	if d.Provider == "none" {
+		return
+	}
+
+	if err := DelegateAcross(p, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+		log.WithFields(log.Fields{"cmd": "dns:" + d.command, "id": d.ParentProvider}).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelZones
+//-----------------------------------------------------------------------------
+
+// DelZones deletes one or more zones from the provider. Recursive deletions
+// purge the records, child zones and parent delegations first.
+func (d *Data) DelZones() {
+
+	d.command = "zone:del"
+	p := d.provider()
+
+	if d.Recursive && d.Provider != "none" {
+		if err := Purge(p, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+			log.WithField("cmd", "dns:"+d.command).Fatal(err)
+		}
+		return
+	}
+
+	if err := p.DeleteZones(d.Zones...); err != nil {
+		log.WithField("cmd", "dns:"+d.command).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: AddRecords
+//-----------------------------------------------------------------------------
+
+// AddRecords adds one or more records to a zone of the provider.
+func (d *Data) AddRecords() {
+	d.command = "record:add"
+	if err := d.provider().CreateRecords(d.Zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "dns:" + d.command, "id": d.Zone}).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelRecords
+//-----------------------------------------------------------------------------
+
+// DelRecords deletes one or more records from a zone of the provider.
+func (d *Data) DelRecords() {
+	d.command = "record:del"
+	if err := d.provider().DeleteRecords(d.Zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "dns:" + d.command, "id": d.Zone}).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: ShowRecords
+//-----------------------------------------------------------------------------
+
+// ShowRecords prints the records of a zone matching the name and type filters.
+func (d *Data) ShowRecords() {
+	d.command = "record:list"
+	if err := Show(d.provider(), d.Zone, d.Name, d.Type, d.Output); err != nil {
+		log.WithFields(log.Fields{"cmd": "dns:" + d.command, "id": d.Zone}).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: provider
+//-----------------------------------------------------------------------------
+
+func (d *Data) provider() Provider {
+
+	p, err := New(d.Provider, d.APIKey)
+	if err != nil {
+		log.WithField("cmd", "dns:"+d.command).Fatal(err)
+	}
+
+	if d.Provider == "none" {
+		log.WithField("cmd", "dns:"+d.command).Info("DNS is disabled, nothing to do")
+	}
+
+	return p
+}
+
+//-----------------------------------------------------------------------------
+// Provider: none
+//-----------------------------------------------------------------------------
+
+// none is used when DNS is disabled, every operation succeeds doing nothing.
+type none struct{}
+
+func (none) CreateZones(zones ...string) error                  { return nil }
+func (none) DeleteZones(zones ...string) error                  { return nil }
+func (none) ListZones() ([]string, error)                       { return nil, nil }
+func (none) NameServers(zone string) ([]string, error)          { return nil, nil }
+func (none) CreateRecords(zone string, records ...string) error { return nil }
+func (none) DeleteRecords(zone string, records ...string) error { return nil }
+func (none) ListRecords(zone string) ([]Record, error)          { return nil, nil }
@@ -0,0 +1,319 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// ExitNoMatch is the exit code of 'record list' when no record matches.
+const ExitNoMatch = 2
+
+// Record types with validated data:
+var validType = map[string]bool{
+	"A": true, "AAAA": true, "CNAME": true, "MX": true,
+	"NS": true, "PTR": true, "SRV": true, "TXT": true,
+}
+
+// Zone file like spec: name [ttl] type data...
+var zoneSpec = regexp.MustCompile(`^(\S+)\s+(?:(\d+)\s+)?(\S+)\s+(.+)$`)
+
+// Hostname label, underscores are used by SRV names:
+var hostLabel = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9])?$`)
+
+//-----------------------------------------------------------------------------
+// func: ParseRecords
+//-----------------------------------------------------------------------------
+
+// ParseRecords parses and validates record specs, the ones sharing name and
+// type are merged into a single record set. Three forms are accepted:
+//
+//	name [ttl] type data...  zone file like: '_etcd._tcp 60 SRV 0 0 2379 q-1.'
+//	{"name": "txt", ...}     JSON encoded Record
+//	name:type:data[,data]    legacy, the data may contain colons
+//
+// TXT data is a single string, optionally quoted. MX data is 'priority host'
+// and SRV data is 'priority weight port target'.
+func ParseRecords(specs ...string) ([]Record, error) {
+
+	records := []Record{}
+	index := map[string]int{}
+
+	for _, spec := range specs {
+
+		r, err := parseRecord(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		// Merge into a previous record set:
+		if i, ok := index[r.Name+" "+r.Type]; ok {
+			if r.TTL != 0 && records[i].TTL != 0 && r.TTL != records[i].TTL {
+				return nil, errors.New("Conflicting TTLs for record: " + r.Name)
+			}
+			if r.TTL != 0 {
+				records[i].TTL = r.TTL
+			}
+			records[i].Data = append(records[i].Data, r.Data...)
+			if err := records[i].Validate(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		index[r.Name+" "+r.Type] = len(records)
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: parseRecord
+//-----------------------------------------------------------------------------
+
+func parseRecord(spec string) (Record, error) {
+
+	r := Record{}
+	spec = strings.TrimSpace(spec)
+	fields := strings.Fields(spec)
+
+	switch {
+
+	// Empty spec:
+	case len(fields) == 0:
+		return r, errors.New("Empty record")
+
+	// JSON:
+	case strings.HasPrefix(spec, "{"):
+		if err := json.Unmarshal([]byte(spec), &r); err != nil {
+			return r, errors.New("Invalid record: " + err.Error())
+		}
+
+	// Legacy name:type:data
+	case strings.Contains(fields[0], ":"):
+		s := strings.SplitN(spec, ":", 3)
+		if len(s) != 3 {
+			return r, errors.New("Invalid record: " + spec)
+		}
+		r.Name, r.Type, r.Data = s[0], s[1], strings.Split(s[2], ",")
+
+	// Zone file like:
+	default:
+		m := zoneSpec.FindStringSubmatch(spec)
+		if m == nil {
+			return r, errors.New("Invalid record: " + spec)
+		}
+		r.Name, r.Type = m[1], m[3]
+		if m[2] != "" {
+			r.TTL, _ = strconv.Atoi(m[2])
+		}
+		if strings.EqualFold(r.Type, "TXT") {
+			r.Data = []string{strings.TrimSuffix(strings.TrimPrefix(m[4], `"`), `"`)}
+		} else {
+			r.Data = []string{strings.Join(strings.Fields(m[4]), " ")}
+		}
+	}
+
+	r.Type = strings.ToUpper(r.Type)
+	return r, r.Validate()
+}
+
+//-----------------------------------------------------------------------------
+// func: Validate
+//-----------------------------------------------------------------------------
+
+// Validate checks the name, the TTL and the data of the record by type.
+func (r *Record) Validate() error {
+
+	if r.Name == "" {
+		return errors.New("Missing record name")
+	}
+
+	if r.TTL < 0 {
+		return errors.New("Invalid TTL for record: " + r.Name)
+	}
+
+	if !validType[r.Type] {
+		return errors.New("Unsupported record type: " + r.Type)
+	}
+
+	if len(r.Data) == 0 {
+		return errors.New("Missing data for record: " + r.Name)
+	}
+
+	if r.Type == "CNAME" && len(r.Data) > 1 {
+		return errors.New("Only one CNAME allowed for record: " + r.Name)
+	}
+
+	for _, data := range r.Data {
+		if !validData(r.Type, data) {
+			return fmt.Errorf("Invalid %s record %s: %q", r.Type, r.Name, data)
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: validData
+//-----------------------------------------------------------------------------
+
+func validData(rtype, data string) bool {
+
+	f := strings.Fields(data)
+
+	switch rtype {
+	case "A":
+		ip := net.ParseIP(data)
+		return ip != nil && ip.To4() != nil
+	case "AAAA":
+		ip := net.ParseIP(data)
+		return ip != nil && ip.To4() == nil
+	case "CNAME", "NS", "PTR":
+		return len(f) == 1 && validHost(f[0])
+	case "MX":
+		return len(f) == 2 && validUint16(f[0]) && validHost(f[1])
+	case "SRV":
+		return len(f) == 4 && validUint16(f[0]) && validUint16(f[1]) &&
+			validUint16(f[2]) && validHost(f[3])
+	case "TXT":
+		return data != "" && len(data) <= 255
+	}
+
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: validHost
+//-----------------------------------------------------------------------------
+
+func validHost(host string) bool {
+
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// func: validUint16
+//-----------------------------------------------------------------------------
+
+func validUint16(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 16)
+	return err == nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Show
+//-----------------------------------------------------------------------------
+
+// Show prints the records of zone matching the name and type filters and
+// exits with ExitNoMatch when none does. Every 'record list' command ends up
+// here so that all the providers behave the same.
+func Show(p Provider, zone, name, rtype, output string) error {
+
+	// Retrieve all the zone records:
+	records, err := p.ListRecords(zone)
+	if err != nil {
+		return err
+	}
+
+	// Filter and print:
+	records = FilterRecords(records, zone, name, rtype)
+	if err := PrintRecords(os.Stdout, records, output); err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		os.Exit(ExitNoMatch)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: FilterRecords
+//-----------------------------------------------------------------------------
+
+// FilterRecords returns the records of zone matching name and type. The name
+// is a glob, relative or fully qualified, the type is case insensitive and
+// empty filters match everything.
+func FilterRecords(records []Record, zone, name, rtype string) []Record {
+
+	if name != "" {
+		name = RelativeName(name, zone)
+	}
+
+	matched := []Record{}
+	for _, r := range records {
+		if name != "" {
+			if ok, _ := path.Match(name, r.Name); !ok {
+				continue
+			}
+		}
+		if rtype != "" && !strings.EqualFold(rtype, r.Type) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+
+	return matched
+}
+
+//-----------------------------------------------------------------------------
+// func: PrintRecords
+//-----------------------------------------------------------------------------
+
+// PrintRecords writes the records as a JSON list or as one line per answer
+// in a zone file like layout.
+func PrintRecords(w io.Writer, records []Record, output string) error {
+
+	if records == nil {
+		records = []Record{}
+	}
+
+	// JSON output:
+	if output == "json" {
+		out, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	}
+
+	// Text output:
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range records {
+		for _, data := range r.Data {
+			fmt.Fprintln(tw, strings.Join([]string{
+				r.Name, strconv.Itoa(r.TTL), r.Type, data}, "\t"))
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,331 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// In-memory provider logging the calls which change something:
+type memProvider struct {
+	name    string
+	zones   map[string][]Record
+	calls   *[]string
+	failDel string // Zone whose deletion fails
+}
+
+func (m *memProvider) log(format string, a ...interface{}) {
+	*m.calls = append(*m.calls, m.name+" "+fmt.Sprintf(format, a...))
+}
+
+func (m *memProvider) CreateZones(zones ...string) error {
+	for _, z := range zones {
+		if _, ok := m.zones[z]; !ok {
+			m.zones[z] = []Record{}
+		}
+	}
+	return nil
+}
+
+func (m *memProvider) DeleteZones(zones ...string) error {
+	for _, z := range zones {
+		if z == m.failDel {
+			return errors.New("Unable to delete " + z)
+		}
+		m.log("zone %s", z)
+		delete(m.zones, z)
+	}
+	return nil
+}
+
+func (m *memProvider) ListZones() ([]string, error) {
+	zones := []string{}
+	for z := range m.zones {
+		zones = append(zones, z+".")
+	}
+	return zones, nil
+}
+
+func (m *memProvider) NameServers(zone string) ([]string, error) {
+	return []string{"ns1." + zone + "."}, nil
+}
+
+func (m *memProvider) CreateRecords(zone string, records ...string) error {
+	r, err := ParseRecords(records...)
+	m.zones[zone] = append(m.zones[zone], r...)
+	return err
+}
+
+func (m *memProvider) DeleteRecords(zone string, records ...string) error {
+	m.log("records %s %d %s", zone, len(records), records[0])
+	return nil
+}
+
+func (m *memProvider) ListRecords(zone string) ([]Record, error) {
+	return m.zones[zone], nil
+}
+
+// newMemProvider returns a provider hosting zones, each one with its apex NS
+// and SOA records plus n A records.
+func newMemProvider(name string, calls *[]string, n int, zones ...string) *memProvider {
+
+	m := &memProvider{name: name, zones: map[string][]Record{}, calls: calls}
+	for _, z := range zones {
+		m.zones[z] = []Record{
+			{Name: "@", Type: "NS", Data: []string{"ns1." + z + "."}},
+			{Name: "@", Type: "SOA", Data: []string{"ns1." + z + ". hostmaster. 1 2 3 4 5"}},
+		}
+		for i := 0; i < n; i++ {
+			m.zones[z] = append(m.zones[z],
+				Record{Name: fmt.Sprintf("h%03d", i), Type: "A", Data: []string{"10.0.0.1"}})
+		}
+	}
+
+	return m
+}
+
+//-----------------------------------------------------------------------------
+// func: TestParseRecords
+//-----------------------------------------------------------------------------
+
+func TestParseRecords(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		specs []string
+		want  []Record
+		fail  bool
+	}{
+		{
+			name:  "zone file like",
+			specs: []string{"_etcd._tcp 60 srv 0 0  2379 q-1."},
+			want:  []Record{{Name: "_etcd._tcp", TTL: 60, Type: "SRV", Data: []string{"0 0 2379 q-1."}}},
+		},
+		{
+			name:  "AAAA with colons",
+			specs: []string{"api AAAA 2001:db8::1", "api:AAAA:2001:db8::2"},
+			want:  []Record{{Name: "api", Type: "AAAA", Data: []string{"2001:db8::1", "2001:db8::2"}}},
+		},
+		{
+			name:  "quoted TXT with spaces",
+			specs: []string{`txt 300 TXT "v=spf1  include:example.com -all"`},
+			want:  []Record{{Name: "txt", TTL: 300, Type: "TXT", Data: []string{"v=spf1  include:example.com -all"}}},
+		},
+		{
+			name:  "unquoted TXT",
+			specs: []string{"txt TXT hello"},
+			want:  []Record{{Name: "txt", Type: "TXT", Data: []string{"hello"}}},
+		},
+		{
+			name:  "JSON",
+			specs: []string{`{"Name": "mx", "Type": "MX", "Data": ["10 mail.example.com."]}`},
+			want:  []Record{{Name: "mx", Type: "MX", Data: []string{"10 mail.example.com."}}},
+		},
+		{
+			name:  "legacy with several data",
+			specs: []string{"master:a:10.0.1.11,10.0.1.12"},
+			want:  []Record{{Name: "master", Type: "A", Data: []string{"10.0.1.11", "10.0.1.12"}}},
+		},
+		{
+			name:  "merged TTL",
+			specs: []string{"www A 10.0.0.1", "www 60 A 10.0.0.2"},
+			want:  []Record{{Name: "www", TTL: 60, Type: "A", Data: []string{"10.0.0.1", "10.0.0.2"}}},
+		},
+		{
+			name:  "distinct types stay apart",
+			specs: []string{"www A 10.0.0.1", "www AAAA ::1"},
+			want: []Record{
+				{Name: "www", Type: "A", Data: []string{"10.0.0.1"}},
+				{Name: "www", Type: "AAAA", Data: []string{"::1"}},
+			},
+		},
+		{name: "conflicting TTLs", specs: []string{"www 60 A 10.0.0.1", "www 30 A 10.0.0.2"}, fail: true},
+		{name: "two CNAMEs", specs: []string{"www CNAME a.", "www CNAME b."}, fail: true},
+		{name: "empty", specs: []string{"  "}, fail: true},
+		{name: "missing data", specs: []string{"www A"}, fail: true},
+		{name: "bad JSON", specs: []string{`{"Name": "www"`}, fail: true},
+		{name: "legacy without data", specs: []string{"www:A"}, fail: true},
+		{name: "unsupported type", specs: []string{"www SOA ns1. hostmaster. 1 2 3 4 5"}, fail: true},
+		{name: "IPv4 as AAAA", specs: []string{"api AAAA 10.0.0.1"}, fail: true},
+		{name: "SRV without target", specs: []string{"_x._tcp SRV 0 0 2379"}, fail: true},
+		{name: "MX without priority", specs: []string{"mx MX mail.example.com."}, fail: true},
+	}
+
+	for _, tt := range tests {
+
+		got, err := ParseRecords(tt.specs...)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", tt.name, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestValidData
+//-----------------------------------------------------------------------------
+
+func TestValidData(t *testing.T) {
+
+	tests := []struct {
+		rtype string
+		data  string
+		valid bool
+	}{
+		{"A", "10.0.1.11", true},
+		{"A", "::ffff:10.0.1.11", true},
+		{"A", "2001:db8::1", false},
+		{"A", "10.0.1", false},
+		{"AAAA", "2001:db8::1", true},
+		{"AAAA", "fe80::1:2:3:4", true},
+		{"AAAA", "10.0.1.11", false},
+		{"AAAA", "2001:db8:::1", false},
+		{"CNAME", "www.example.com.", true},
+		{"CNAME", "www.example.com", true},
+		{"CNAME", "-www.example.com", false},
+		{"CNAME", "a b", false},
+		{"NS", "ns-1.awsdns-1.org.", true},
+		{"PTR", "", false},
+		{"MX", "10 mail.example.com.", true},
+		{"MX", "65535 mail", true},
+		{"MX", "65536 mail", false},
+		{"MX", "-1 mail", false},
+		{"MX", "mail.example.com.", false},
+		{"MX", "10 mail bad", false},
+		{"SRV", "0 0 2379 q-1.int.example.com.", true},
+		{"SRV", "10 5 5060 _sip.example.com", true},
+		{"SRV", "0 0 70000 q-1.", false},
+		{"SRV", "0 0 q-1.", false},
+		{"SRV", "0 0 2379 q_1..", false},
+		{"TXT", "v=spf1 -all", true},
+		{"TXT", "", false},
+		{"TXT", string(make([]byte, 256)), false},
+		{"SOA", "ns1. hostmaster. 1 2 3 4 5", false},
+	}
+
+	for _, tt := range tests {
+		if got := validData(tt.rtype, tt.data); got != tt.valid {
+			t.Errorf("validData(%s, %q) = %v, want %v", tt.rtype, tt.data, got, tt.valid)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestPurgeZones
+//-----------------------------------------------------------------------------
+
+func TestPurgeZones(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		zones   []string // Hosted by the purged provider
+		parents []string // Hosted by the parent provider
+		records int      // A records per zone
+		failDel string
+		purge   []string
+		want    []string
+		summary []Purged
+		fail    bool
+	}{
+		{
+			name:    "children first",
+			zones:   []string{"example.com", "int.example.com", "a.int.example.com", "b.int.example.com", "example.org"},
+			records: 1,
+			purge:   []string{"int.example.com."},
+			want: []string{
+				"p records a.int.example.com 1 h000:A",
+				"p records int.example.com 1 a:NS",
+				"p zone a.int.example.com",
+				"p records b.int.example.com 1 h000:A",
+				"p records int.example.com 1 b:NS",
+				"p zone b.int.example.com",
+				"p records int.example.com 1 h000:A",
+				"p records example.com 1 int:NS",
+				"p zone int.example.com",
+			},
+			summary: []Purged{
+				{Zone: "a.int.example.com", Records: 1, Parent: "int.example.com"},
+				{Zone: "b.int.example.com", Records: 1, Parent: "int.example.com"},
+				{Zone: "int.example.com", Records: 1, Parent: "example.com"},
+			},
+		},
+		{
+			name:    "delegation in the parent provider",
+			zones:   []string{"int.example.com"},
+			parents: []string{"example.com"},
+			purge:   []string{"int.example.com"},
+			want: []string{
+				"parent records example.com 1 int:NS",
+				"p zone int.example.com",
+			},
+			summary: []Purged{{Zone: "int.example.com", Parent: "example.com"}},
+		},
+		{
+			name:    "batches",
+			zones:   []string{"int.example.com"},
+			records: 2*PurgeBatch + 1,
+			purge:   []string{"int.example.com"},
+			want: []string{
+				fmt.Sprintf("p records int.example.com %d h000:A", PurgeBatch),
+				fmt.Sprintf("p records int.example.com %d h%03d:A", PurgeBatch, PurgeBatch),
+				fmt.Sprintf("p records int.example.com 1 h%03d:A", 2*PurgeBatch),
+				"p zone int.example.com",
+			},
+			summary: []Purged{{Zone: "int.example.com", Records: 2*PurgeBatch + 1}},
+		},
+		{
+			name:    "stops at the first error",
+			zones:   []string{"example.com", "int.example.com", "a.int.example.com"},
+			failDel: "a.int.example.com",
+			purge:   []string{"int.example.com"},
+			want: []string{
+				"p records int.example.com 1 a:NS",
+			},
+			summary: []Purged{{Zone: "a.int.example.com", Parent: "int.example.com"}},
+			fail:    true,
+		},
+	}
+
+	for _, tt := range tests {
+
+		calls := []string{}
+		p := newMemProvider("p", &calls, tt.records, tt.zones...)
+		p.failDel = tt.failDel
+
+		var parent Provider
+		if tt.parents != nil {
+			parent = newMemProvider("parent", &calls, 0, tt.parents...)
+		}
+
+		summary, err := PurgeZones(p, parent, tt.purge...)
+		if tt.fail != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+
+		if !reflect.DeepEqual(calls, tt.want) {
+			t.Errorf("%s: got calls\n%s\nwant\n%s", tt.name,
+				strings.Join(calls, "\n"), strings.Join(tt.want, "\n"))
+		}
+
+		if !reflect.DeepEqual(summary, tt.summary) {
+			t.Errorf("%s: got summary %+v, want %+v", tt.name, summary, tt.summary)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package dns
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// PurgeBatch is the number of records deleted per request by PurgeZones.
+const PurgeBatch = 100
+
+// Purged summarizes the recursive deletion of a zone.
+type Purged struct {
+	Zone    string `json:"zone"`
+	Records int    `json:"records"` // Deleted records (name and type pairs)
+	Parent  string `json:"parent"`  // Zone the delegation was removed from
+}
+
+// ParentFinder is implemented by the providers unable to list their zones.
+// They find the closest parent of a zone by other means.
+type ParentFinder interface {
+	FindParent(zone string) (string, error)
+}
+
+// Zone names sorted deepest first:
+type byDepth []string
+
+func (z byDepth) Len() int      { return len(z) }
+func (z byDepth) Swap(i, j int) { z[i], z[j] = z[j], z[i] }
+func (z byDepth) Less(i, j int) bool {
+	ni, nj := strings.Count(z[i], "."), strings.Count(z[j], ".")
+	return ni > nj || (ni == nj && z[i] < z[j])
+}
+
+//-----------------------------------------------------------------------------
+// func: FindParent
+//-----------------------------------------------------------------------------
+
+// FindParent returns the closest zone of p which is a parent of zone, or an
+// empty string if there is none.
+func FindParent(p Provider, zone string) (string, error) {
+
+	if f, ok := p.(ParentFinder); ok {
+		return f.FindParent(zone)
+	}
+
+	zones, err := p.ListZones()
+	if err != nil {
+		return "", err
+	}
+
+	zone = strings.TrimSuffix(zone, ".")
+	parent := ""
+	for _, z := range zones {
+		z = strings.TrimSuffix(z, ".")
+		if strings.HasSuffix(zone, "."+z) && len(z) > len(parent) {
+			parent = z
+		}
+	}
+
+	return parent, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Delegate
+//-----------------------------------------------------------------------------
+
+// Delegate publishes the name servers of zone, hosted by child, as NS records
+// of its closest parent zone hosted by parent. The parent zone is returned,
+// nothing is done if there is none.
+func Delegate(child, parent Provider, zone string) (string, error) {
+
+	// Find the parent zone:
+	pZone, err := FindParent(parent, zone)
+	if err != nil || pZone == "" {
+		return "", err
+	}
+
+	// Get the child name servers:
+	ns, err := child.NameServers(zone)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ns) == 0 {
+		return "", errors.New("No name servers found for zone " + zone)
+	}
+
+	// Add the NS records to the parent zone:
+	return pZone, parent.CreateRecords(pZone,
+		RelativeName(zone, pZone)+":NS:"+strings.Join(ns, ","))
+}
+
+//-----------------------------------------------------------------------------
+// func: DelegateAcross
+//-----------------------------------------------------------------------------
+
+// DelegateAcross delegates the zones of child which have no parent within
+// child from their closest parent zone in the named provider, if any. This
+// way a zone hosted by NS1 can be reached from its Route 53 parent and vice
+// versa.
+func DelegateAcross(child Provider, parentName, parentKey string, zones ...string) error {
+
+	if parentName == "" || parentName == "none" {
+		return nil
+	}
+
+	parent, err := New(parentName, parentKey)
+	if err != nil {
+		return err
+	}
+
+	for _, zone := range zones {
+
+		// Already delegated within the child provider:
+		pZone, err := FindParent(child, zone)
+		if err != nil {
+			return err
+		}
+
+		if pZone != "" {
+			continue
+		}
+
+		if _, err := Delegate(child, parent, zone); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Purge
+//-----------------------------------------------------------------------------
+
+// Purge runs PurgeZones with the named parent provider, if any, and prints
+// the summary to stdout. Every 'zone del --recursive' command ends up here.
+func Purge(p Provider, parentName, parentKey string, zones ...string) error {
+
+	var parent Provider
+	if parentName != "" && parentName != "none" {
+		var err error
+		if parent, err = New(parentName, parentKey); err != nil {
+			return err
+		}
+	}
+
+	summary, err := PurgeZones(p, parent, zones...)
+	if perr := PrintPurged(os.Stdout, summary); err == nil {
+		err = perr
+	}
+
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: PurgeZones
+//-----------------------------------------------------------------------------
+
+// PurgeZones deletes zones along with their child zones hosted by p, deepest
+// first. The records of every zone are deleted in batches, then the NS records
+// delegating it from its parent zone, found in p or else in parent (if not
+// nil), and finally the zone itself. The summary is returned even on error.
+func PurgeZones(p, parent Provider, zones ...string) ([]Purged, error) {
+
+	summary := []Purged{}
+
+	// Add the child zones:
+	all, err := p.ListZones()
+	if err != nil {
+		return summary, err
+	}
+
+	purge := map[string]bool{}
+	for _, zone := range zones {
+		zone = strings.TrimSuffix(zone, ".")
+		purge[zone] = true
+		for _, z := range all {
+			if z = strings.TrimSuffix(z, "."); strings.HasSuffix(z, "."+zone) {
+				purge[z] = true
+			}
+		}
+	}
+
+	// Deepest first:
+	sorted := []string{}
+	for zone := range purge {
+		sorted = append(sorted, zone)
+	}
+
+	sort.Sort(byDepth(sorted))
+
+	for _, zone := range sorted {
+		s, err := purgeZone(p, parent, zone)
+		summary = append(summary, s)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	return summary, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: purgeZone
+//-----------------------------------------------------------------------------
+
+func purgeZone(p, parent Provider, zone string) (Purged, error) {
+
+	s := Purged{Zone: zone}
+
+	// Every record but the apex NS and SOA:
+	records, err := p.ListRecords(zone)
+	if err != nil {
+		return s, err
+	}
+
+	specs, seen := []string{}, map[string]bool{}
+	for _, r := range records {
+		spec := r.Name + ":" + r.Type
+		if (r.Name == "@" && (r.Type == "NS" || r.Type == "SOA")) || seen[spec] {
+			continue
+		}
+		seen[spec] = true
+		specs = append(specs, spec)
+	}
+
+	// Delete them in batches:
+	for i := 0; i < len(specs); i += PurgeBatch {
+		batch := specs[i:]
+		if len(batch) > PurgeBatch {
+			batch = batch[:PurgeBatch]
+		}
+		if err := p.DeleteRecords(zone, batch...); err != nil {
+			return s, err
+		}
+		s.Records += len(batch)
+	}
+
+	// Remove the delegation from the parent zone:
+	for _, pp := range []Provider{p, parent} {
+
+		if pp == nil {
+			continue
+		}
+
+		pZone, err := FindParent(pp, zone)
+		if err != nil {
+			return s, err
+		}
+
+		if pZone != "" {
+			if err := pp.DeleteRecords(pZone, RelativeName(zone, pZone)+":NS"); err != nil {
+				return s, err
+			}
+			s.Parent = pZone
+			break
+		}
+	}
+
+	// Delete the zone:
+	return s, p.DeleteZones(zone)
+}
+
+//-----------------------------------------------------------------------------
+// func: PrintPurged
+//-----------------------------------------------------------------------------
+
+// PrintPurged writes the summary of PurgeZones, one line per zone.
+func PrintPurged(w io.Writer, summary []Purged) error {
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ZONE\tRECORDS\tDELEGATION")
+
+	for _, s := range summary {
+		parent := s.Parent
+		if parent == "" {
+			parent = "-"
+		}
+		fmt.Fprintln(tw, s.Zone+"\t"+strconv.Itoa(s.Records)+"\t"+parent)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,184 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"os"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/katosys/kato/pkg/cluster"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// func: Apply
+//-----------------------------------------------------------------------------
+
+// Apply converges the cluster towards the nodes described by the quadruplets.
+// A new cluster is deployed from scratch, an existing one gets its setup
+// refreshed and its missing nodes added. Nodes are never removed, and the
+// changes which can't reach the running nodes are reported as a failure.
+func (d *Data) Apply() {
+
+	// Set current command:
+	d.command = "apply"
+
+	// Count quorum and master nodes:
+	d.QuorumCount = kato.CountNodes(d.Quadruplets, "quorum")
+	d.MasterCount = kato.CountNodes(d.Quadruplets, "master")
+
+	// Keep other operators out from the read to the last node:
+	defer d.lockState()()
+
+	// Deploy from scratch if there is no state:
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+			Info("No state found, deploying a new cluster")
+		if err := d.deploy(); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		return
+	}
+
+	// Quorum and master counts are baked into every node:
+	if raw, _, _, err = MigrateState(raw); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	old := State{}
+	if err := json.Unmarshal(raw, &old); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	if old.QuorumCount != d.QuorumCount || old.MasterCount != d.MasterCount {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+			Fatal("Quorum and master counts can't be changed on a running cluster")
+	}
+
+	// Some settings are wired into the VPC or into every node:
+	fixed, stale := specDrift(&old, &d.State)
+	if len(fixed) > 0 {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+			Fatal("Can't be changed on a running cluster: " + strings.Join(fixed, ", "))
+	}
+
+	stale = append(stale, poolDrift(old.Quadruplets, d.Quadruplets)...)
+
+	// Keep the etcd token of the running cluster:
+	d.EtcdToken = old.EtcdToken
+
+	// Converge the VPC, IAM and EC2 components:
+	d.setupAPIEndpoints()
+	if err := d.setup(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	// Add the missing nodes:
+	extra, err := cluster.Converge(d, d.clusterData(), "existing")
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	// Report what the running nodes still lack:
+	for _, f := range stale {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": f}).
+			Warning("Changed in the spec, only the nodes added from now on get it")
+	}
+
+	// Report the nodes not described by the quadruplets:
+	for _, n := range extra {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": n.FQDN(d.Domain)}).
+			Warning("Node not in spec, left untouched")
+	}
+
+	if len(stale) > 0 || len(extra) > 0 {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+			Fatal("The cluster does not match the spec, replace or remove the nodes above")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: specDrift
+//-----------------------------------------------------------------------------
+
+// specDrift compares the spec with the stored state. Fixed settings can't be
+// changed once the cluster runs. Stale settings are rendered into the user
+// data of every node, so the running nodes keep the stored values. Settings
+// missing from older state files are not reported as fixed.
+func specDrift(old, spec *State) (fixed, stale []string) {
+
+	for _, f := range []struct {
+		name      string
+		old, spec string
+		fixed     bool
+	}{
+		{"domain", old.Domain, spec.Domain, true},
+		{"provider.region", old.Region, spec.Region, true},
+		{"provider.zone", old.Zone, spec.Zone, true},
+		{"provider.vpcCidrBlock", old.VpcCidrBlock, spec.VpcCidrBlock, true},
+		{"provider.internalSubnetCidr", old.IntSubnetCidr, spec.IntSubnetCidr, true},
+		{"provider.externalSubnetCidr", old.ExtSubnetCidr, spec.ExtSubnetCidr, true},
+		{"dns.provider", old.DNSProvider, spec.DNSProvider, true},
+		{"features.calicoIPPool", old.CalicoIPPool, spec.CalicoIPPool, true},
+		{"provider.keyPair", old.KeyPair, spec.KeyPair, false},
+		{"provider.coreOSChannel", old.CoreOSChannel, spec.CoreOSChannel, false},
+		{"dns.apiKey", old.DNSApiKey, spec.DNSApiKey, false},
+		{"alerting.slackWebhook", old.SlackWebhook, spec.SlackWebhook, false},
+		{"alerting.smtpURL", old.SMTPURL, spec.SMTPURL, false},
+		{"alerting.adminEmail", old.AdminEmail, spec.AdminEmail, false},
+		{"features.caCertPath", old.CaCertPath, spec.CaCertPath, false},
+		{"features.stubZones", strings.Join(old.StubZones, ","), strings.Join(spec.StubZones, ","), false},
+	} {
+		switch {
+		case f.old == f.spec:
+		case f.fixed && f.old != "":
+			fixed = append(fixed, f.name)
+		case !f.fixed:
+			stale = append(stale, f.name)
+		}
+	}
+
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: poolDrift
+//-----------------------------------------------------------------------------
+
+// poolDrift returns the pools whose running nodes were launched with another
+// instance type or other roles than the spec now says.
+func poolDrift(old, spec []string) (stale []string) {
+
+	running := map[string][]string{}
+	for _, q := range old {
+		s := strings.Split(q, ":")
+		running[s[2]] = s
+	}
+
+	for _, q := range spec {
+		s := strings.Split(q, ":")
+		r, ok := running[s[2]]
+		if !ok || r[0] == "0" {
+			continue
+		}
+		if r[1] != s[1] {
+			stale = append(stale, "pools."+s[2]+".instanceType")
+		}
+		if r[3] != s[3] {
+			stale = append(stale, "pools."+s[2]+".roles")
+		}
+	}
+
+	return
+}
@@ -0,0 +1,784 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/katosys/kato/pkg/dns"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Resources that could not be deleted:
+type leftovers []leftover
+
+type leftover struct {
+	kind string
+	id   string
+	err  error
+}
+
+//-----------------------------------------------------------------------------
+// func: check
+//-----------------------------------------------------------------------------
+
+// check records the resource as a leftover if err is not nil and tells
+// whether the resource is gone.
+func (l *leftovers) check(kind, id string, err error) bool {
+	if err != nil {
+		*l = append(*l, leftover{kind, id, err})
+		return false
+	}
+	return true
+}
+
+//-----------------------------------------------------------------------------
+// func: Destroy
+//-----------------------------------------------------------------------------
+
+// Destroy tears down the cluster described by the state file in reverse
+// dependency order. Failures don't stop the teardown, they are reported as
+// leftovers at the end. The state file is archived in any case.
+func (d *Data) Destroy() {
+
+	// Set current command:
+	d.command = "destroy"
+
+	// Print the plan and bail out:
+	if d.DryRun {
+		if err := d.loadState(); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		d.setupAPIEndpoints()
+		p := plan{}
+		d.planDestroy(&p)
+		p.print()
+		return
+	}
+
+	// The operator must agree, there is no way back:
+	if !d.Yes {
+		if err := d.confirm(); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+	}
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Load state from state file:
+	if err := d.loadState(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	d.setupAPIEndpoints()
+	left := leftovers{}
+
+	// Terminate the instances:
+	instances, err := d.clusterInstances()
+	if left.check("ec2:instance", d.VpcID, err) {
+		left.check("ec2:instance", d.VpcID, d.terminateInstances(instances))
+	}
+
+	// Remove the DNS records and zones:
+	if d.DNSProvider != "" && d.DNSProvider != "none" {
+		d.deleteDNS(instances, &left)
+	}
+
+	// Delete the load balancer:
+	if d.DNSName != "" && left.check("elb:load-balancer", d.ClusterID, d.deleteELB()) {
+		d.DNSName = ""
+	}
+
+	// Delete the NAT gateway and release its elastic IP:
+	if d.NatGatewayID != "" && left.check("ec2:nat-gateway", d.NatGatewayID, d.deleteNatGateway()) {
+		d.NatGatewayID = ""
+	}
+
+	if d.AllocationID != "" && left.check("ec2:elastic-ip", d.AllocationID, d.releaseElasticIP(d.AllocationID)) {
+		d.AllocationID = ""
+	}
+
+	// Delete the security groups:
+	d.deleteSecurityGroups(&left)
+
+	// Delete the routing:
+	if d.RouteTableID != "" && left.check("ec2:route-table", d.RouteTableID, d.deleteRouteTable()) {
+		d.RouteTableID = ""
+	}
+
+	if d.InetGatewayID != "" && left.check("ec2:internet-gateway", d.InetGatewayID, d.deleteInternetGateway()) {
+		d.InetGatewayID = ""
+	}
+
+	// Delete the subnets:
+	for _, id := range []*string{&d.IntSubnetID, &d.ExtSubnetID} {
+		if *id != "" && left.check("ec2:subnet", *id, d.deleteSubnet(*id)) {
+			*id = ""
+		}
+	}
+
+	// Delete the VPC:
+	if d.VpcID != "" && left.check("ec2:vpc", d.VpcID, d.deleteVPC()) {
+		d.VpcID, d.MainRouteTableID = "", ""
+	}
+
+	// The IAM role, profile and policy are shared by all the clusters:
+	if d.DeleteIAM {
+		d.deleteIAMSecurity(&left)
+	} else {
+		log.WithField("cmd", "ec2:"+d.command).
+			Info("Keeping the kato IAM role, use --delete-iam to delete it")
+	}
+
+	// Report the leftovers:
+	for _, l := range left {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "kind": l.kind, "id": l.id}).
+			Warning(l.err)
+	}
+
+	if len(left) > 0 {
+		log.WithField("cmd", "ec2:"+d.command).
+			Warning("Some resources were left behind, see the archived state")
+	}
+
+	// Dump what is left and archive the state file:
+	if err := d.dumpState(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	path, err := kato.ArchiveState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": path}).
+		Info("State file archived")
+}
+
+//-----------------------------------------------------------------------------
+// func: confirm
+//-----------------------------------------------------------------------------
+
+// confirm asks the operator to type the cluster ID. Without a terminal there
+// is nobody to ask, so --yes is required.
+func (d *Data) confirm() error {
+
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("Refusing to destroy " + d.ClusterID + " unattended, use --yes")
+	}
+
+	fmt.Fprintf(os.Stderr, "This deletes %s, its nodes and its DNS zones.\n"+
+		"Type the cluster ID to confirm: ", d.ClusterID)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(answer) != d.ClusterID {
+		return errors.New("The cluster ID does not match, nothing was destroyed")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: clusterInstances
+//-----------------------------------------------------------------------------
+
+func (d *Data) clusterInstances() ([]*ec2.Instance, error) {
+
+	// Nothing to look for:
+	if d.VpcID == "" {
+		return nil, nil
+	}
+
+	// Forge the description request:
+	params := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("vpc-id"),
+				Values: []*string{aws.String(d.VpcID)},
+			},
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []*string{aws.String("pending"), aws.String("running"),
+					aws.String("shutting-down"), aws.String("stopping"), aws.String("stopped")},
+			},
+		},
+	}
+
+	// Collect the instances:
+	instances := []*ec2.Instance{}
+	err := d.ec2.DescribeInstancesPages(params,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range page.Reservations {
+				instances = append(instances, r.Instances...)
+			}
+			return true
+		})
+
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: terminateInstances
+//-----------------------------------------------------------------------------
+
+func (d *Data) terminateInstances(instances []*ec2.Instance) error {
+
+	// Nothing to terminate:
+	if len(instances) == 0 {
+		return nil
+	}
+
+	ids := []*string{}
+	for _, i := range instances {
+		ids = append(ids, i.InstanceId)
+	}
+
+	// Elastic IPs allocated by 'ec2 run' are not in the state:
+	addrs, err := d.ec2.DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: ids,
+			},
+		},
+	})
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	// Send the termination request:
+	if _, err := d.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: ids,
+	}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	// Wait until the instances are terminated:
+	log.WithField("cmd", "ec2:"+d.command).
+		Info("Waiting until all instances are terminated")
+	if err := d.ec2.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{
+		InstanceIds: ids,
+	}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithField("cmd", "ec2:"+d.command).Info("All instances terminated")
+
+	// Release the per-instance elastic IPs:
+	for _, a := range addrs.Addresses {
+		if a.AllocationId != nil && *a.AllocationId != d.AllocationID {
+			if err := d.releaseElasticIP(*a.AllocationId); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteDNS
+//-----------------------------------------------------------------------------
+
+// deleteDNS removes the records published by 'ec2 add'. The zones created by
+// the deploy, as recorded in DNSZones, are deleted along with the records
+// delegating them. Zones which existed before the cluster are left alone.
+func (d *Data) deleteDNS(instances []*ec2.Instance, left *leftovers) {
+
+	records, created := d.dnsTeardown(instances)
+
+	provider, err := dns.New(d.DNSProvider, d.DNSApiKey)
+	if err != nil {
+		left.check(d.DNSProvider+":zone", d.Domain, err)
+		return
+	}
+
+	// Delete the records:
+	for zone, list := range records {
+		if len(list) > 0 {
+			left.check(d.DNSProvider+":record", zone, provider.DeleteRecords(zone, list...))
+		}
+	}
+
+	// Delete the created zones, children first:
+	kept := []string{}
+	for _, zone := range []string{"int." + d.Domain, "ext." + d.Domain, d.Domain} {
+		if !created[zone] {
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": zone}).
+				Info("DNS zone not created by this cluster, left in place")
+			continue
+		}
+		if !left.check(d.DNSProvider+":zone", zone, provider.DeleteZones(zone)) {
+			kept = append(kept, zone)
+		}
+	}
+
+	d.DNSZones = kept
+}
+
+//-----------------------------------------------------------------------------
+// func: dnsTeardown
+//-----------------------------------------------------------------------------
+
+// dnsTeardown returns the records to delete per zone and the zones created
+// by the deploy.
+func (d *Data) dnsTeardown(instances []*ec2.Instance) (map[string][]string, map[string]bool) {
+
+	created := map[string]bool{}
+	for _, zone := range d.DNSZones {
+		created[strings.TrimSuffix(zone, ".")] = true
+	}
+
+	// Records per zone:
+	records := map[string][]string{
+		"int." + d.Domain: {},
+		"ext." + d.Domain: {},
+		d.Domain:          {},
+	}
+
+	for _, child := range []string{"int", "ext"} {
+		if created[child+"."+d.Domain] || created[d.Domain] {
+			records[d.Domain] = append(records[d.Domain], child+":NS")
+		}
+	}
+
+	// One set of records per node and role:
+	for _, i := range instances {
+		hostID := d.hostID(i)
+		if hostID == "" {
+			continue
+		}
+		for _, role := range d.instanceRoles(i) {
+			name := role + "-" + hostID
+			records["int."+d.Domain] = append(records["int."+d.Domain], name+":A")
+			records["ext."+d.Domain] = append(records["ext."+d.Domain], name+":A")
+			records[d.Domain] = append(records[d.Domain], name+":CNAME")
+		}
+	}
+
+	return records, created
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteELB
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteELB() error {
+
+	// Send the ELB deletion request:
+	if _, err := d.elb.DeleteLoadBalancer(&elb.DeleteLoadBalancerInput{
+		LoadBalancerName: aws.String(d.ClusterID),
+	}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+		Info("ELB deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteNatGateway
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteNatGateway() error {
+
+	// Send the NAT gateway deletion request:
+	if _, err := d.ec2.DeleteNatGateway(&ec2.DeleteNatGatewayInput{
+		NatGatewayId: aws.String(d.NatGatewayID),
+	}); err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	// Wait until the NAT gateway is deleted:
+	log.WithField("cmd", "ec2:"+d.command).
+		Info("Waiting until NAT gateway is deleted")
+	for i := 0; i < 60; i++ {
+
+		resp, err := d.ec2.DescribeNatGateways(&ec2.DescribeNatGatewaysInput{
+			NatGatewayIds: []*string{aws.String(d.NatGatewayID)},
+		})
+		if err != nil && !notFound(err) {
+			log.WithField("cmd", "ec2:"+d.command).Error(err)
+			return err
+		}
+
+		if err != nil || len(resp.NatGateways) == 0 || *resp.NatGateways[0].State == "deleted" {
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.NatGatewayID}).
+				Info("NAT gateway deleted")
+			return nil
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+
+	return errors.New("Timeout waiting for the NAT gateway to be deleted")
+}
+
+//-----------------------------------------------------------------------------
+// func: releaseElasticIP
+//-----------------------------------------------------------------------------
+
+func (d *Data) releaseElasticIP(id string) error {
+
+	// Send the release request:
+	if _, err := d.ec2.ReleaseAddress(&ec2.ReleaseAddressInput{
+		AllocationId: aws.String(id),
+	}); err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": id}).
+		Info("Elastic IP released")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteSecurityGroups
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteSecurityGroups(left *leftovers) {
+
+	// Every security group in the state:
+	groups := map[string]*string{"elb": &d.ELBSecGrp}
+	for name := range d.SecGrps {
+		id := d.SecGrps[name]
+		groups[name] = &id
+	}
+
+	// Groups reference each other so revoke all the rules first:
+	for name, id := range groups {
+		if *id != "" {
+			left.check("ec2:security-group", name, d.revokeSecurityGroup(*id))
+		}
+	}
+
+	// Delete the groups:
+	for name, id := range groups {
+		if *id != "" && left.check("ec2:security-group", name, d.deleteSecurityGroup(*id)) {
+			*id = ""
+			if name != "elb" {
+				delete(d.SecGrps, name)
+			}
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: revokeSecurityGroup
+//-----------------------------------------------------------------------------
+
+func (d *Data) revokeSecurityGroup(id string) error {
+
+	// Retrieve the current rules:
+	resp, err := d.ec2.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(id)},
+	})
+	if err != nil {
+		if notFound(err) {
+			return nil
+		}
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	if len(resp.SecurityGroups) == 0 || len(resp.SecurityGroups[0].IpPermissions) == 0 {
+		return nil
+	}
+
+	// Send the revoke request:
+	if _, err := d.ec2.RevokeSecurityGroupIngress(&ec2.RevokeSecurityGroupIngressInput{
+		GroupId:       aws.String(id),
+		IpPermissions: resp.SecurityGroups[0].IpPermissions,
+	}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteSecurityGroup
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteSecurityGroup(id string) error {
+
+	// ELB network interfaces take a while to go away:
+	err := retryDependency(func() error {
+		_, err := d.ec2.DeleteSecurityGroup(&ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(id),
+		})
+		return err
+	})
+
+	if err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": id}).
+		Info("Security group deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteRouteTable
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteRouteTable() error {
+
+	// Retrieve the route table associations:
+	resp, err := d.ec2.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
+		RouteTableIds: []*string{aws.String(d.RouteTableID)},
+	})
+	if err != nil {
+		if notFound(err) {
+			return nil
+		}
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	// Disassociate the subnets:
+	for _, t := range resp.RouteTables {
+		for _, a := range t.Associations {
+			if _, err := d.ec2.DisassociateRouteTable(&ec2.DisassociateRouteTableInput{
+				AssociationId: a.RouteTableAssociationId,
+			}); err != nil {
+				log.WithField("cmd", "ec2:"+d.command).Error(err)
+				return err
+			}
+		}
+	}
+
+	// Send the route table deletion request:
+	if _, err := d.ec2.DeleteRouteTable(&ec2.DeleteRouteTableInput{
+		RouteTableId: aws.String(d.RouteTableID),
+	}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.RouteTableID}).
+		Info("Route table deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteInternetGateway
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteInternetGateway() error {
+
+	// Send the detachment request:
+	if _, err := d.ec2.DetachInternetGateway(&ec2.DetachInternetGatewayInput{
+		InternetGatewayId: aws.String(d.InetGatewayID),
+		VpcId:             aws.String(d.VpcID),
+	}); err != nil && !notFound(err) {
+		ec2err, ok := err.(awserr.Error)
+		if !ok || ec2err.Code() != "Gateway.NotAttached" {
+			log.WithField("cmd", "ec2:"+d.command).Error(err)
+			return err
+		}
+	}
+
+	// Send the deletion request:
+	if _, err := d.ec2.DeleteInternetGateway(&ec2.DeleteInternetGatewayInput{
+		InternetGatewayId: aws.String(d.InetGatewayID),
+	}); err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.InetGatewayID}).
+		Info("Internet gateway deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteSubnet
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteSubnet(id string) error {
+
+	// Network interfaces take a while to go away:
+	err := retryDependency(func() error {
+		_, err := d.ec2.DeleteSubnet(&ec2.DeleteSubnetInput{
+			SubnetId: aws.String(id),
+		})
+		return err
+	})
+
+	if err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": id}).
+		Info("Subnet deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteVPC
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteVPC() error {
+
+	// Send the VPC deletion request:
+	err := retryDependency(func() error {
+		_, err := d.ec2.DeleteVpc(&ec2.DeleteVpcInput{
+			VpcId: aws.String(d.VpcID),
+		})
+		return err
+	})
+
+	if err != nil && !notFound(err) {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.VpcID}).
+		Info("VPC deleted")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteIAMSecurity
+//-----------------------------------------------------------------------------
+
+func (d *Data) deleteIAMSecurity(left *leftovers) {
+
+	// Remove the role from the instance profile:
+	if _, err := d.iam.RemoveRoleFromInstanceProfile(&iam.RemoveRoleFromInstanceProfileInput{
+		InstanceProfileName: aws.String("kato"),
+		RoleName:            aws.String("kato"),
+	}); err != nil && !notFound(err) {
+		left.check("iam:instance-profile", "kato", err)
+		return
+	}
+
+	// Delete the instance profile:
+	if _, err := d.iam.DeleteInstanceProfile(&iam.DeleteInstanceProfileInput{
+		InstanceProfileName: aws.String("kato"),
+	}); err != nil && !notFound(err) {
+		left.check("iam:instance-profile", "kato", err)
+		return
+	}
+
+	// Detach policies from the role:
+	for _, policy := range []string{
+		"arn:aws:iam::aws:policy/AmazonS3FullAccess",
+		"arn:aws:iam::aws:policy/AmazonRoute53FullAccess",
+		d.RexrayPolicy,
+	} {
+		if policy == "" {
+			continue
+		}
+		if _, err := d.iam.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			PolicyArn: aws.String(policy),
+			RoleName:  aws.String("kato"),
+		}); err != nil && !notFound(err) {
+			left.check("iam:role", "kato", err)
+			return
+		}
+	}
+
+	// Delete the role:
+	if _, err := d.iam.DeleteRole(&iam.DeleteRoleInput{
+		RoleName: aws.String("kato"),
+	}); err != nil && !notFound(err) {
+		left.check("iam:role", "kato", err)
+		return
+	}
+
+	d.KatoRoleID = ""
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "kato"}).
+		Info("IAM role and instance profile deleted")
+
+	// Delete the REX-Ray policy:
+	if d.RexrayPolicy == "" {
+		return
+	}
+
+	if _, err := d.iam.DeletePolicy(&iam.DeletePolicyInput{
+		PolicyArn: aws.String(d.RexrayPolicy),
+	}); err != nil && !notFound(err) {
+		left.check("iam:policy", d.RexrayPolicy, err)
+		return
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.RexrayPolicy}).
+		Info("REX-Ray IAM policy deleted")
+	d.RexrayPolicy = ""
+}
+
+//-----------------------------------------------------------------------------
+// func: retryDependency
+//-----------------------------------------------------------------------------
+
+// retryDependency retries f while AWS reports a dependency violation.
+func retryDependency(f func() error) (err error) {
+	for i := 0; i < 30; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		ec2err, ok := err.(awserr.Error)
+		if !ok || ec2err.Code() != "DependencyViolation" {
+			return err
+		}
+		time.Sleep(10 * time.Second)
+	}
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: notFound
+//-----------------------------------------------------------------------------
+
+// notFound tells whether err means the resource is already gone.
+func notFound(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && (strings.Contains(awsErr.Code(), "NotFound") ||
+		awsErr.Code() == "NoSuchEntity")
+}
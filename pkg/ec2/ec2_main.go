@@ -8,6 +8,7 @@ import (
 
 	// Stdlib:
 	"encoding/json"
+	"strconv"
 	"strings"
 	"time"
 
@@ -55,48 +56,65 @@ type Instance struct {
 
 // State data.
 type State struct {
-	Quadruplets      []string `json:"-"`                // deploy |       | add |
-	StubZones        []string `json:"StubZones"`        // deploy |       | add |
-	QuorumCount      int      `json:"QuorumCount"`      // deploy |       | add |
-	MasterCount      int      `json:"MasterCount"`      // deploy |       | add |
-	CoreOSChannel    string   `json:"CoreOSChannel"`    // deploy |       | add |
-	EtcdToken        string   `json:"EtcdToken"`        // deploy |       | add |
-	DNSProvider      string   `json:"DNSProvider"`      // deploy |       | add |
-	DNSApiKey        string   `json:"DNSApiKey"`        // deploy |       | add |
-	SlackWebhook     string   `json:"SlackWebhook:"`    // deploy |       | add |
-	SMTPURL          string   `json:"SMTPURL:"`         // deploy |       | add |
-	AdminEmail       string   `json:"AdminEmail:"`      // deploy |       | add |
-	CaCertPath       string   `json:"CaCertPath"`       // deploy |       | add |
-	CalicoIPPool     string   `json:"CalicoIPPool"`     // deploy |       |     |
-	Domain           string   `json:"Domain"`           // deploy | setup | add |
-	ClusterID        string   `json:"ClusterID"`        // deploy | setup | add |
-	Region           string   `json:"Region"`           // deploy | setup | add | run
-	Zone             string   `json:"Zone"`             // deploy | setup | add | run
-	VpcCidrBlock     string   `json:"VpcCidrBlock"`     // deploy | setup |     |
-	IntSubnetCidr    string   `json:"IntSubnetCidr"`    // deploy | setup |     |
-	ExtSubnetCidr    string   `json:"ExtSubnetCidr"`    // deploy | setup |     |
-	AllocationID     string   `json:"AllocationID"`     //        | setup |     | run
-	VpcID            string   `json:"VpcID"`            //        | setup |     |
-	MainRouteTableID string   `json:"MainRouteTableID"` //        | setup |     |
-	InetGatewayID    string   `json:"InetGatewayID"`    //        | setup |     |
-	NatGatewayID     string   `json:"NatGatewayID"`     //        | setup |     |
-	RouteTableID     string   `json:"RouteTableID"`     //        | setup |     |
-	KatoRoleID       string   `json:"KatoRoleID"`       //        | setup |     |
-	RexrayPolicy     string   `json:"RexrayPolicy"`     //        | setup |     |
-	QuorumSecGrp     string   `json:"QuorumSecGrp"`     //        | setup |     |
-	MasterSecGrp     string   `json:"MasterSecGrp"`     //        | setup |     |
-	WorkerSecGrp     string   `json:"WorkerSecGrp"`     //        | setup |     |
-	BorderSecGrp     string   `json:"BorderSecGrp"`     //        | setup |     |
-	ELBSecGrp        string   `json:"ELBSecGrp"`        //        | setup |     |
-	IntSubnetID      string   `json:"IntSubnetID"`      //        | setup |     |
-	ExtSubnetID      string   `json:"ExtSubnetID"`      //        | setup |     |
-	DNSName          string   `json:"DNSName"`          //        | setup |     |
-	KeyPair          string   `json:"KeyPair"`          //        |       | add | run
+	Version          int               `json:"Version"`          // deploy | setup |     |
+	Quadruplets      []string          `json:"Quadruplets"`      // deploy |       | add |
+	StubZones        []string          `json:"StubZones"`        // deploy |       | add |
+	QuorumCount      int               `json:"QuorumCount"`      // deploy |       | add |
+	MasterCount      int               `json:"MasterCount"`      // deploy |       | add |
+	CoreOSChannel    string            `json:"CoreOSChannel"`    // deploy |       | add |
+	EtcdToken        string            `json:"EtcdToken"`        // deploy |       | add |
+	DNSProvider      string            `json:"DNSProvider"`      // deploy |       | add |
+	DNSApiKey        string            `json:"DNSApiKey"`        // deploy |       | add |
+	DNSZones         []string          `json:"DNSZones"`         // deploy |       |     |
+	SlackWebhook     string            `json:"SlackWebhook"`     // deploy |       | add |
+	SMTPURL          string            `json:"SMTPURL"`          // deploy |       | add |
+	AdminEmail       string            `json:"AdminEmail"`       // deploy |       | add |
+	SecretsURL       string            `json:"SecretsURL"`       // deploy |       | add |
+	CaCertPath       string            `json:"CaCertPath"`       // deploy |       | add |
+	CalicoIPPool     string            `json:"CalicoIPPool"`     // deploy |       |     |
+	Domain           string            `json:"Domain"`           // deploy | setup | add |
+	ClusterID        string            `json:"ClusterID"`        // deploy | setup | add |
+	Region           string            `json:"Region"`           // deploy | setup | add | run
+	Zone             string            `json:"Zone"`             // deploy | setup | add | run
+	VpcCidrBlock     string            `json:"VpcCidrBlock"`     // deploy | setup |     |
+	IntSubnetCidr    string            `json:"IntSubnetCidr"`    // deploy | setup |     |
+	ExtSubnetCidr    string            `json:"ExtSubnetCidr"`    // deploy | setup |     |
+	AllocationID     string            `json:"AllocationID"`     //        | setup |     | run
+	VpcID            string            `json:"VpcID"`            //        | setup |     |
+	MainRouteTableID string            `json:"MainRouteTableID"` //        | setup |     |
+	InetGatewayID    string            `json:"InetGatewayID"`    //        | setup |     |
+	NatGatewayID     string            `json:"NatGatewayID"`     //        | setup |     |
+	RouteTableID     string            `json:"RouteTableID"`     //        | setup |     |
+	KatoRoleID       string            `json:"KatoRoleID"`       //        | setup |     |
+	RexrayPolicy     string            `json:"RexrayPolicy"`     //        | setup |     |
+	SecGrps          map[string]string `json:"SecGrps"`          //        | setup |     |
+	ELBSecGrp        string            `json:"ELBSecGrp"`        //        | setup |     |
+	IntSubnetID      string            `json:"IntSubnetID"`      //        | setup |     |
+	ExtSubnetID      string            `json:"ExtSubnetID"`      //        | setup |     |
+	DNSName          string            `json:"DNSName"`          //        | setup |     |
+	KeyPair          string            `json:"KeyPair"`          //        |       | add | run
+}
+
+// Node removal and replacement data.
+type NodeOp struct {
+	Node          string        // <role>-<id> of the target node
+	EtcdEndpoint  string        // Any etcd member reachable from here
+	MesosEndpoint string        // Any Mesos master reachable from here
+	DrainTimeout  time.Duration // Time given to Mesos tasks to move away
+	Timeout       time.Duration // Time given to a new node to be healthy
+	JumpHost      string        // SSH host the private IPs are reached through
+	SSHUser       string        // SSH user on the jump host
+	freshIP       bool          // Launch masters off their reserved IP
+	tunnels       map[string]*tunnel
 }
 
 // Data struct for EC2 endpoints, instance and state data.
 type Data struct {
-	command string
+	command   string
+	DryRun    bool // Print the plan instead of applying it
+	DeleteIAM bool // Delete the shared IAM role on destroy
+	Yes       bool // Destroy without asking for confirmation
+	NodeOp
 	svc
 	Instance
 	State
@@ -114,6 +132,18 @@ func (d *Data) loadState() error {
 		return err
 	}
 
+	// Upgrade older state files:
+	raw, from, applied, err := MigrateState(raw)
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
+	}
+
+	if len(applied) > 0 {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.ClusterID}).
+			Info("State upgraded from version " + strconv.Itoa(from))
+	}
+
 	// Decode the loaded JSON data:
 	dat := State{}
 	if err := json.Unmarshal(raw, &dat); err != nil {
@@ -130,6 +160,43 @@ func (d *Data) loadState() error {
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: dumpState
+//-----------------------------------------------------------------------------
+
+// dumpState writes the state stamped with the current schema version.
+func (d *Data) dumpState() error {
+	d.Version = StateVersion
+	return kato.DumpState(d.State, d.ClusterID)
+}
+
+//-----------------------------------------------------------------------------
+// func: lockState
+//-----------------------------------------------------------------------------
+
+// lockState takes the state lock or exits. The returned function releases
+// the lock, it also runs if the command exits with a fatal error.
+func (d *Data) lockState() func() {
+
+	unlock, err := kato.LockState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			if err := unlock(); err != nil {
+				log.WithField("cmd", "ec2:"+d.command).Warning(err)
+			}
+		}
+	}
+
+	log.RegisterExitHandler(release)
+	return release
+}
+
 //-----------------------------------------------------------------------------
 // func: tag
 //-----------------------------------------------------------------------------
@@ -165,3 +232,58 @@ func (d *Data) tag(resource, key, value string) error {
 
 	return nil
 }
+
+//-----------------------------------------------------------------------------
+// func: hasRole
+//-----------------------------------------------------------------------------
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//-----------------------------------------------------------------------------
+// func: instanceRoles
+//-----------------------------------------------------------------------------
+
+// instanceRoles maps the security groups of the instance back to roles.
+func (d *Data) instanceRoles(i *ec2.Instance) (roles []string) {
+	for _, role := range kato.Roles {
+		for _, g := range i.SecurityGroups {
+			if id, ok := d.SecGrps[role.Name]; ok && *g.GroupId == id {
+				roles = append(roles, role.Name)
+			}
+		}
+	}
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: hostID
+//-----------------------------------------------------------------------------
+
+// hostID extracts <host-id> from the <host-name>-<host-id>.<domain> tag.
+func (d *Data) hostID(i *ec2.Instance) string {
+	name := strings.TrimSuffix(tagName(i), "."+d.Domain)
+	if name == "" {
+		return ""
+	}
+	return name[strings.LastIndex(name, "-")+1:]
+}
+
+//-----------------------------------------------------------------------------
+// func: tagName
+//-----------------------------------------------------------------------------
+
+func tagName(i *ec2.Instance) string {
+	for _, t := range i.Tags {
+		if *t.Key == "Name" {
+			return *t.Value
+		}
+	}
+	return ""
+}
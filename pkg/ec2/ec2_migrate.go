@@ -0,0 +1,177 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+//-----------------------------------------------------------------------------
+// State schema versions:
+//-----------------------------------------------------------------------------
+
+// StateVersion is the schema version of the state files written by this
+// katoctl. State files without a Version field are version 0.
+const StateVersion = 3
+
+// A migration upgrades the raw JSON state from version to version+1.
+type migration struct {
+	version     int
+	description string
+	migrate     func(map[string]json.RawMessage) error
+}
+
+// Migrations in order, one per version:
+var migrations = []migration{
+	{0, "Fold the per role security groups into SecGrps", migrateSecGrps},
+	{1, "Drop the trailing colon of the alerting field names", migrateColonTags},
+	{2, "Record the int and ext zones as created by the cluster", migrateDNSZones},
+}
+
+//-----------------------------------------------------------------------------
+// func: MigrateState
+//-----------------------------------------------------------------------------
+
+// MigrateState upgrades a raw JSON state to StateVersion. It returns the
+// upgraded state, the version it was found at and the applied migrations.
+func MigrateState(raw []byte) ([]byte, int, []string, error) {
+
+	// Decode the raw state:
+	state := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, 0, nil, err
+	}
+
+	// Read the version:
+	from := 0
+	if v, ok := state["Version"]; ok {
+		if err := json.Unmarshal(v, &from); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	if from > StateVersion {
+		return nil, from, nil, errors.New("State version " + strconv.Itoa(from) +
+			" is newer than " + strconv.Itoa(StateVersion) + ", upgrade katoctl")
+	}
+
+	// Nothing to do:
+	if from == StateVersion {
+		return raw, from, nil, nil
+	}
+
+	// Apply the migrations:
+	applied := []string{}
+	for _, m := range migrations[from:] {
+		if err := m.migrate(state); err != nil {
+			return nil, from, applied, errors.New("Migration to version " +
+				strconv.Itoa(m.version+1) + ": " + err.Error())
+		}
+		applied = append(applied, strconv.Itoa(m.version)+" -> "+
+			strconv.Itoa(m.version+1)+": "+m.description)
+	}
+
+	state["Version"] = json.RawMessage(strconv.Itoa(StateVersion))
+	out, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, from, applied, err
+	}
+
+	return out, from, applied, nil
+}
+
+//-----------------------------------------------------------------------------
+// Migrations:
+//-----------------------------------------------------------------------------
+
+// migrateSecGrps moves QuorumSecGrp, MasterSecGrp, WorkerSecGrp and
+// BorderSecGrp into the SecGrps role registry.
+func migrateSecGrps(state map[string]json.RawMessage) error {
+
+	secGrps := map[string]string{}
+	if raw, ok := state["SecGrps"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &secGrps); err != nil {
+			return err
+		}
+	}
+
+	for role, key := range map[string]string{
+		"quorum": "QuorumSecGrp",
+		"master": "MasterSecGrp",
+		"worker": "WorkerSecGrp",
+		"border": "BorderSecGrp",
+	} {
+		if raw, ok := state[key]; ok {
+			var id string
+			if err := json.Unmarshal(raw, &id); err != nil {
+				return err
+			}
+			if _, ok := secGrps[role]; !ok && id != "" {
+				secGrps[role] = id
+			}
+			delete(state, key)
+		}
+	}
+
+	if len(secGrps) > 0 {
+		raw, err := json.Marshal(secGrps)
+		if err != nil {
+			return err
+		}
+		state["SecGrps"] = raw
+	}
+
+	return nil
+}
+
+// migrateColonTags renames "SlackWebhook:", "SMTPURL:" and "AdminEmail:".
+func migrateColonTags(state map[string]json.RawMessage) error {
+
+	for _, key := range []string{"SlackWebhook", "SMTPURL", "AdminEmail"} {
+		if raw, ok := state[key+":"]; ok {
+			if _, ok := state[key]; !ok {
+				state[key] = raw
+			}
+			delete(state, key+":")
+		}
+	}
+
+	return nil
+}
+
+// migrateDNSZones fills DNSZones, which tells destroy the zones it may delete.
+// The apex zone might have existed before the cluster so it is left out.
+func migrateDNSZones(state map[string]json.RawMessage) error {
+
+	if _, ok := state["DNSZones"]; ok {
+		return nil
+	}
+
+	var domain, provider string
+	for key, value := range map[string]*string{"Domain": &domain, "DNSProvider": &provider} {
+		if raw, ok := state[key]; ok {
+			if err := json.Unmarshal(raw, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	zones := []string{}
+	if domain != "" && provider != "" && provider != "none" {
+		zones = append(zones, "int."+domain, "ext."+domain)
+	}
+
+	raw, err := json.Marshal(zones)
+	if err != nil {
+		return err
+	}
+
+	state["DNSZones"] = raw
+	return nil
+}
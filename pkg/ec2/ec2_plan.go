@@ -0,0 +1,312 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/katosys/kato/pkg/dns"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Changes computed in dry-run mode:
+type plan []change
+
+type change struct {
+	action string // create | ensure | keep | delete
+	kind   string // Resource kind
+	name   string // Resource name or ID
+	detail string // Resource attributes
+}
+
+//-----------------------------------------------------------------------------
+// func: add
+//-----------------------------------------------------------------------------
+
+func (p *plan) add(action, kind, name, detail string) {
+	*p = append(*p, change{action, kind, name, detail})
+}
+
+// addOrKeep plans the creation of a resource unless id is already known.
+func (p *plan) addOrKeep(id, kind, name, detail string) {
+	if id != "" {
+		p.add("keep", kind, id, name)
+		return
+	}
+	p.add("create", kind, name, detail)
+}
+
+//-----------------------------------------------------------------------------
+// func: print
+//-----------------------------------------------------------------------------
+
+// print writes the plan to stdout so it can be reviewed or archived.
+func (p plan) print() {
+
+	symbols := map[string]string{"create": "+", "ensure": "~", "keep": "=", "delete": "-"}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	count := map[string]int{}
+
+	for _, c := range p {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", symbols[c.action], c.kind, c.name, c.detail)
+		count[c.action]++
+	}
+
+	_ = w.Flush()
+	fmt.Printf("\nPlan: %d to create, %d to ensure, %d to keep, %d to delete.\n",
+		count["create"], count["ensure"], count["keep"], count["delete"])
+}
+
+//-----------------------------------------------------------------------------
+// func: planSetup
+//-----------------------------------------------------------------------------
+
+// planSetup mirrors Setup() without calling the AWS API.
+func (d *Data) planSetup(p *plan) {
+
+	// VPC:
+	p.addOrKeep(d.VpcID, "ec2:vpc", d.Domain, "cidr="+d.VpcCidrBlock)
+
+	// Subnets:
+	if d.IntSubnetCidr != "" {
+		p.addOrKeep(d.IntSubnetID, "ec2:subnet", "internal",
+			"cidr="+d.IntSubnetCidr+" zone="+d.Region+d.Zone)
+	}
+	p.addOrKeep(d.ExtSubnetID, "ec2:subnet", "external",
+		"cidr="+d.ExtSubnetCidr+" zone="+d.Region+d.Zone)
+
+	// Routing:
+	p.addOrKeep(d.RouteTableID, "ec2:route-table", "external", "")
+	p.add("ensure", "ec2:route-table-association", "external", "subnet=external")
+	p.addOrKeep(d.InetGatewayID, "ec2:internet-gateway", d.Domain, "")
+	p.add("ensure", "ec2:internet-gateway-attachment", d.Domain, "vpc="+d.Domain)
+	p.add("ensure", "ec2:route", "0.0.0.0/0", "table=external via=internet-gateway")
+	p.addOrKeep(d.AllocationID, "ec2:elastic-ip", "nat", "domain=vpc")
+
+	if d.IntSubnetCidr != "" {
+		p.addOrKeep(d.NatGatewayID, "ec2:nat-gateway", d.Domain, "subnet=external")
+		p.add("ensure", "ec2:route", "0.0.0.0/0", "table=main via=nat-gateway")
+	}
+
+	// IAM:
+	p.addOrKeep(d.RexrayPolicy, "iam:policy", "REX-Ray", "path=/kato/")
+	p.addOrKeep(d.KatoRoleID, "iam:role", "kato", "path=/kato/")
+	p.add("ensure", "iam:instance-profile", "kato", "path=/kato/ role=kato")
+	for _, policy := range []string{"AmazonS3FullAccess", "AmazonRoute53FullAccess", "REX-Ray"} {
+		p.add("ensure", "iam:role-policy", "kato", "policy="+policy)
+	}
+
+	// Security groups:
+	for _, role := range kato.Roles {
+		p.addOrKeep(d.SecGrps[role.Name], "ec2:security-group", role.Name, "vpc="+d.Domain)
+	}
+
+	for _, role := range kato.Roles {
+		p.add("ensure", "ec2:ingress", role.Name, "all from="+strings.Join(kato.RoleNames(), ","))
+		for _, in := range role.Ingress {
+			p.add("ensure", "ec2:ingress", role.Name, fmt.Sprintf("%s %d-%d from=%s",
+				in.Protocol, in.FromPort, in.ToPort, in.CidrIP))
+		}
+	}
+
+	// Load balancer:
+	p.addOrKeep(d.ELBSecGrp, "ec2:security-group", "elb", "vpc="+d.Domain)
+	p.addOrKeep(d.DNSName, "elb:load-balancer", d.ClusterID, "listeners=tcp:80,tcp:443 subnet=external")
+	p.add("ensure", "ec2:ingress", "elb", "tcp 80-80 from=0.0.0.0/0")
+	p.add("ensure", "ec2:ingress", "elb", "tcp 443-443 from=0.0.0.0/0")
+}
+
+//-----------------------------------------------------------------------------
+// func: planAdd
+//-----------------------------------------------------------------------------
+
+// planAdd mirrors Add() without calling the AWS nor the DNS provider APIs.
+func (d *Data) planAdd(p *plan) {
+
+	name := d.HostName + "-" + d.HostID + "." + d.Domain
+	detail := "type=" + d.InstanceType + " roles=" + d.Roles + " state=" + d.ClusterState
+
+	// AMI:
+	if d.AmiID != "" {
+		detail += " ami=" + d.AmiID
+	} else {
+		detail += " ami=latest-" + d.CoreOSChannel
+	}
+
+	// Network:
+	privateIP := "<assigned>"
+	if strings.Contains(d.Roles, "master") {
+		i, _ := strconv.Atoi(d.HostID)
+		privateIP = kato.OffsetIP(d.ExtSubnetCidr, 10+i)
+		detail += " private-ip=" + privateIP
+	}
+
+	for _, r := range strings.Split(d.Roles, ",") {
+		if role, ok := kato.GetRole(r); ok && role.ELB {
+			detail += " elb=" + d.ClusterID
+			break
+		}
+	}
+
+	p.add("create", "ec2:instance", name, detail)
+
+	// DNS records:
+	if d.DNSProvider == "none" {
+		return
+	}
+
+	for _, role := range strings.Split(d.Roles, ",") {
+		record := role + "-" + d.HostID
+		p.add("ensure", d.DNSProvider+":record", record+".int."+d.Domain, "A "+privateIP)
+		p.add("ensure", d.DNSProvider+":record", record+".ext."+d.Domain, "A <public-ip>")
+		p.add("ensure", d.DNSProvider+":record", record+"."+d.Domain, "CNAME "+record+".int."+d.Domain)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: planDeploy
+//-----------------------------------------------------------------------------
+
+// planDeploy mirrors Deploy() without calling any remote API.
+func (d *Data) planDeploy(p *plan) {
+
+	// Setup the environment:
+	d.planSetup(p)
+
+	if d.DNSProvider != "none" {
+		p.add("ensure", d.DNSProvider+":zone", d.Domain, "")
+		p.add("ensure", d.DNSProvider+":zone", "ext."+d.Domain, "")
+		provider, err := dns.New(d.DNSProvider, "")
+		if err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		if _, ok := provider.(dns.PrivateZoner); ok {
+			vpc := d.VpcID
+			if vpc == "" {
+				vpc = "(new)"
+			}
+			p.add("ensure", d.DNSProvider+":zone", "int."+d.Domain, "private vpc="+vpc)
+		} else {
+			p.add("ensure", d.DNSProvider+":zone", "int."+d.Domain, "")
+		}
+	}
+
+	p.add("create", "etcd:discovery-token", d.ClusterID, "size="+strconv.Itoa(d.QuorumCount))
+
+	// Deploy all the nodes:
+	for _, q := range d.Quadruplets {
+		s := strings.Split(q, ":")
+		count, _ := strconv.Atoi(s[0])
+		for i := 1; i <= count; i++ {
+			n := *d
+			n.InstanceType, n.HostName, n.Roles = s[1], s[2], s[3]
+			n.HostID, n.ClusterState = strconv.Itoa(i), "new"
+			n.planAdd(p)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: planDestroy
+//-----------------------------------------------------------------------------
+
+// planDestroy mirrors Destroy(), the instances are only looked up.
+func (d *Data) planDestroy(p *plan) {
+
+	// Instances:
+	instances, err := d.clusterInstances()
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	for _, i := range instances {
+		p.add("delete", "ec2:instance", *i.InstanceId, tagName(i))
+	}
+
+	// DNS records and zones:
+	if d.DNSProvider != "" && d.DNSProvider != "none" {
+		records, created := d.dnsTeardown(instances)
+		zones := []string{"int." + d.Domain, "ext." + d.Domain, d.Domain}
+		for _, zone := range zones {
+			for _, r := range records[zone] {
+				s := strings.Split(r, ":")
+				p.add("delete", d.DNSProvider+":record", s[0]+"."+zone, s[1])
+			}
+		}
+		for _, zone := range zones {
+			if created[zone] {
+				p.add("delete", d.DNSProvider+":zone", zone, "")
+			} else {
+				p.add("keep", d.DNSProvider+":zone", zone, "not created by this cluster")
+			}
+		}
+	}
+
+	// Load balancer and NAT:
+	if d.DNSName != "" {
+		p.add("delete", "elb:load-balancer", d.ClusterID, d.DNSName)
+	}
+
+	if d.NatGatewayID != "" {
+		p.add("delete", "ec2:nat-gateway", d.NatGatewayID, "")
+	}
+
+	if d.AllocationID != "" {
+		p.add("delete", "ec2:elastic-ip", d.AllocationID, "nat")
+	}
+
+	// Security groups:
+	names := []string{}
+	for name := range d.SecGrps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p.add("delete", "ec2:security-group", d.SecGrps[name], name)
+	}
+
+	if d.ELBSecGrp != "" {
+		p.add("delete", "ec2:security-group", d.ELBSecGrp, "elb")
+	}
+
+	// Routing, subnets and VPC:
+	for _, r := range []struct{ kind, id, name string }{
+		{"ec2:route-table", d.RouteTableID, "external"},
+		{"ec2:internet-gateway", d.InetGatewayID, d.Domain},
+		{"ec2:subnet", d.IntSubnetID, "internal"},
+		{"ec2:subnet", d.ExtSubnetID, "external"},
+		{"ec2:vpc", d.VpcID, d.Domain},
+	} {
+		if r.id != "" {
+			p.add("delete", r.kind, r.id, r.name)
+		}
+	}
+
+	// IAM:
+	action := "keep"
+	if d.DeleteIAM {
+		action = "delete"
+	}
+
+	p.add(action, "iam:role", "kato", "path=/kato/")
+	p.add(action, "iam:instance-profile", "kato", "path=/kato/")
+	if d.RexrayPolicy != "" {
+		p.add(action, "iam:policy", d.RexrayPolicy, "REX-Ray")
+	}
+}
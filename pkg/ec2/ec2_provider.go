@@ -0,0 +1,180 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+
+	// Community:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/katosys/kato/pkg/cluster"
+	"github.com/katosys/kato/pkg/udata"
+)
+
+//-----------------------------------------------------------------------------
+// func: Name
+//-----------------------------------------------------------------------------
+
+// Name returns the provider name.
+func (d *Data) Name() string {
+	return "ec2"
+}
+
+//-----------------------------------------------------------------------------
+// func: Udata
+//-----------------------------------------------------------------------------
+
+// Udata sets the EC2 specific user data flags.
+func (d *Data) Udata(flags *udata.CmdFlags) {
+	flags.Ec2Region = d.Region
+	flags.GzipUdata = true
+	flags.RexrayStorageDriver = "ebs"
+}
+
+//-----------------------------------------------------------------------------
+// func: RunInstance
+//-----------------------------------------------------------------------------
+
+// RunInstance launches the node with the given user data. Nodes are run
+// concurrently so every launch gets its own run data.
+func (d *Data) RunInstance(n *cluster.Node, udata []byte) error {
+
+	// Retrieve the CoreOS AMI ID (unless given):
+	amiID := d.AmiID
+	if amiID == "" {
+		var err error
+		if amiID, err = d.retrieveCoreOSAmiID(); err != nil {
+			return err
+		}
+	}
+
+	r := d.forgeRun(n, amiID)
+
+	// Run the EC2 instance:
+	if err := r.runInstance(udata); err != nil {
+		return err
+	}
+
+	// Set before anything else can fail, so that it can be cleaned up:
+	n.ID = r.InstanceID
+
+	// Modify instance attributes:
+	return r.modifyInstanceAttribute()
+}
+
+//-----------------------------------------------------------------------------
+// func: TerminateInstance
+//-----------------------------------------------------------------------------
+
+// TerminateInstance terminates the node and releases its elastic IP.
+func (d *Data) TerminateInstance(n *cluster.Node) error {
+	return d.terminateInstances([]*ec2.Instance{{InstanceId: aws.String(n.ID)}})
+}
+
+//-----------------------------------------------------------------------------
+// func: ListInstances
+//-----------------------------------------------------------------------------
+
+// ListInstances returns the pending and running nodes of the VPC.
+func (d *Data) ListInstances() ([]*cluster.Node, error) {
+
+	instances, err := d.clusterInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*cluster.Node{}
+	for _, i := range instances {
+
+		// Skip the stopped and unnamed instances:
+		state, hostID := *i.State.Name, d.hostID(i)
+		if (state != "pending" && state != "running") || hostID == "" {
+			continue
+		}
+
+		name := strings.TrimSuffix(tagName(i), "."+d.Domain)
+		nodes = append(nodes, &cluster.Node{
+			ID:           *i.InstanceId,
+			HostName:     strings.TrimSuffix(name, "-"+hostID),
+			HostID:       hostID,
+			Roles:        d.instanceRoles(i),
+			InstanceType: *i.InstanceType,
+		})
+	}
+
+	return nodes, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: InstanceIPs
+//-----------------------------------------------------------------------------
+
+// InstanceIPs waits for the addresses of the node.
+func (d *Data) InstanceIPs(n *cluster.Node) (*cluster.IPs, error) {
+	r := &Data{command: d.command, svc: d.svc, Instance: Instance{InstanceID: n.ID}}
+	return r.instanceIPs()
+}
+
+//-----------------------------------------------------------------------------
+// func: AttachBalancer
+//-----------------------------------------------------------------------------
+
+// AttachBalancer registers the node with the cluster ELB.
+func (d *Data) AttachBalancer(n *cluster.Node) error {
+	r := &Data{command: d.command, svc: d.svc,
+		Instance: Instance{InstanceID: n.ID, ELBName: d.ClusterID}}
+	return r.registerWithELB()
+}
+
+//-----------------------------------------------------------------------------
+// func: PrivateNetwork
+//-----------------------------------------------------------------------------
+
+// PrivateNetwork returns the VPC of the cluster and its region.
+func (d *Data) PrivateNetwork() (string, string) {
+	return d.VpcID, d.Region
+}
+
+//-----------------------------------------------------------------------------
+// func: SaveState
+//-----------------------------------------------------------------------------
+
+// SaveState dumps the state with the cluster data decided by the orchestrator.
+func (d *Data) SaveState(c *cluster.Cluster) error {
+	d.EtcdToken = c.EtcdToken
+	d.QuorumCount = c.QuorumCount
+	d.MasterCount = c.MasterCount
+	d.DNSZones = c.DNSZones
+	return d.dumpState()
+}
+
+//-----------------------------------------------------------------------------
+// func: clusterData
+//-----------------------------------------------------------------------------
+
+// clusterData returns the provider agnostic part of the state.
+func (d *Data) clusterData() *cluster.Cluster {
+	return &cluster.Cluster{
+		ClusterID:    d.ClusterID,
+		Domain:       d.Domain,
+		Quadruplets:  d.Quadruplets,
+		QuorumCount:  d.QuorumCount,
+		MasterCount:  d.MasterCount,
+		EtcdToken:    d.EtcdToken,
+		DNSProvider:  d.DNSProvider,
+		DNSApiKey:    d.DNSApiKey,
+		CalicoIPPool: d.CalicoIPPool,
+		CaCertPath:   d.CaCertPath,
+		StubZones:    d.StubZones,
+		SlackWebhook: d.SlackWebhook,
+		SMTPURL:      d.SMTPURL,
+		AdminEmail:   d.AdminEmail,
+		SecretsURL:   d.SecretsURL,
+		DNSZones:     d.DNSZones,
+	}
+}
@@ -0,0 +1,482 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Mesos machine ID as registered by the agents:
+type mesosMachine struct {
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+}
+
+// Mesos maintenance schedule (existing windows are kept verbatim):
+type mesosSchedule struct {
+	Windows []json.RawMessage `json:"windows"`
+}
+
+//-----------------------------------------------------------------------------
+// func: Remove
+//-----------------------------------------------------------------------------
+
+// Remove takes a <role>-<id> node out of the cluster: Mesos masters and
+// agents are drained, quorum nodes leave etcd, then the instance is
+// terminated and its DNS records and etcd host entries are deleted.
+func (d *Data) Remove() {
+
+	// Set current command:
+	d.command = "remove"
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Load state from state file:
+	if err := d.loadState(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	d.setupAPIEndpoints()
+
+	// Locate the node and its peers:
+	node, peers, err := d.locateNode()
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.Node}).Fatal(err)
+	}
+
+	// Reach the private IPs through a border node:
+	d.setupJumpHost(peers)
+	defer d.closeTunnels()
+
+	hostID := d.hostID(node)
+	roles := d.instanceRoles(node)
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": *node.InstanceId}).
+		Info("Removing " + tagName(node) + " [" + strings.Join(roles, ",") + "]")
+
+	// Default to the endpoints of the peers:
+	if d.EtcdEndpoint == "" {
+		d.EtcdEndpoint = d.peerEndpoint(peers, "quorum", "2379")
+	}
+
+	if d.MesosEndpoint == "" {
+		d.MesosEndpoint = d.mesosLeader(d.peerEndpoint(peers, "master", "5050"))
+	}
+
+	// Drain Mesos:
+	machines := []mesosMachine{}
+	for _, r := range roles {
+		if r == "master" || r == "worker" {
+			machines = append(machines, mesosMachine{
+				Hostname: r + "-" + hostID + "." + d.Domain,
+				IP:       *node.PrivateIpAddress,
+			})
+		}
+	}
+
+	if len(machines) > 0 {
+		if err := d.drainMesos(machines); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+	}
+
+	// Leave the etcd cluster (the ZooKeeper seat is kept, see zkCheckMajority):
+	if hasRole(roles, "quorum") {
+		if err := d.zkCheckMajority(peers); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		if err := d.removeEtcdMember("quorum-" + hostID); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+		hostName := strings.TrimSuffix(strings.TrimSuffix(tagName(node), "."+d.Domain), "-"+hostID)
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "quorum-" + hostID}).
+			Warning("The ZooKeeper ensemble keeps this seat empty and tolerates one failure less. " +
+				"Add the node back with 'katoctl ec2 add --cluster-id " + d.ClusterID +
+				" --host-name " + hostName + " --host-id " + hostID + " --instance-type " +
+				*node.InstanceType + " --roles " + strings.Join(roles, ",") +
+				" --cluster-state existing' and 'etcdctl member add quorum-" + hostID +
+				" http://<new-private-ip>:2380'")
+	}
+
+	// Terminate the instance:
+	if err := d.terminateInstances([]*ec2.Instance{node}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	// Delete the DNS records:
+	if d.DNSProvider != "" && d.DNSProvider != "none" {
+		if err := d.deleteDNSRecords(roles, hostID); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Warning(err)
+		}
+	}
+
+	// Delete the host entries (they also feed the Prometheus targets):
+	if d.EtcdEndpoint != "" {
+		for _, r := range roles {
+			if err := d.removeEtcdKey("/hosts/" + r + "/" + tagName(node)); err != nil {
+				log.WithField("cmd", "ec2:"+d.command).Warning(err)
+			}
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteDNSRecords
+//-----------------------------------------------------------------------------
+
+// deleteDNSRecords removes the records published by 'ec2 add' for a node,
+// failed deletions are only reported.
+func (d *Data) deleteDNSRecords(roles []string, hostID string) error {
+
+	provider, err := dns.New(d.DNSProvider, d.DNSApiKey)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range roles {
+		name := r + "-" + hostID
+		for zone, record := range map[string]string{
+			"int." + d.Domain: name + ":A",
+			"ext." + d.Domain: name + ":A",
+			d.Domain:          name + ":CNAME",
+		} {
+			if err := provider.DeleteRecords(zone, record); err != nil {
+				log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": name + "." + zone}).
+					Warning(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: locateNode
+//-----------------------------------------------------------------------------
+
+// locateNode returns the <role>-<id> instance and the rest of the cluster.
+func (d *Data) locateNode() (*ec2.Instance, []*ec2.Instance, error) {
+
+	// Split <role>-<id>:
+	sep := strings.LastIndex(d.Node, "-")
+	role, hostID := d.Node[:sep], d.Node[sep+1:]
+	if _, ok := d.SecGrps[role]; !ok {
+		return nil, nil, errors.New("Unknown role " + role)
+	}
+
+	// Retrieve all the cluster instances:
+	instances, err := d.clusterInstances()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var node *ec2.Instance
+	peers := []*ec2.Instance{}
+	for _, i := range instances {
+		if node == nil && d.hostID(i) == hostID && hasRole(d.instanceRoles(i), role) {
+			node = i
+			continue
+		}
+		peers = append(peers, i)
+	}
+
+	if node == nil || node.PrivateIpAddress == nil {
+		return nil, nil, errors.New("Node not found")
+	}
+
+	return node, peers, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: peerEndpoint
+//-----------------------------------------------------------------------------
+
+// peerEndpoint returns the URL of the first running peer with the given role,
+// tunneled through the jump host if any.
+func (d *Data) peerEndpoint(peers []*ec2.Instance, role, port string) string {
+	for _, i := range peers {
+		if *i.State.Name == "running" && i.PrivateIpAddress != nil &&
+			hasRole(d.instanceRoles(i), role) {
+			return "http://" + d.reach(*i.PrivateIpAddress, port)
+		}
+	}
+	return ""
+}
+
+//-----------------------------------------------------------------------------
+// func: zkCheckMajority
+//-----------------------------------------------------------------------------
+
+// zkCheckMajority fails unless the ZooKeeper servers of the peers keep a
+// majority without the node. ZooKeeper 3.4 has no 'reconfig': the ensemble
+// is static and its server list is derived from QuorumCount on every node,
+// so the removed server keeps its seat until a quorum node with the same ID
+// is added back. Shrinking the list would mean re-rendering and restarting
+// every node for a count that can't change on a running cluster.
+func (d *Data) zkCheckMajority(peers []*ec2.Instance) error {
+
+	serving := 0
+	for _, i := range peers {
+		if *i.State.Name == "running" && i.PrivateIpAddress != nil &&
+			hasRole(d.instanceRoles(i), "quorum") &&
+			zkServing(d.reach(*i.PrivateIpAddress, "2181")) {
+			serving++
+		}
+	}
+
+	if majority := d.QuorumCount/2 + 1; serving < majority {
+		return errors.New("Only " + strconv.Itoa(serving) + " other ZooKeeper servers are serving, " +
+			strconv.Itoa(majority) + " are needed to keep a majority")
+	}
+
+	log.WithField("cmd", "ec2:"+d.command).
+		Info(strconv.Itoa(serving) + " other ZooKeeper servers keep the majority")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: drainMesos
+//-----------------------------------------------------------------------------
+
+// drainMesos schedules a maintenance window for the machines, waits for their
+// tasks to go away and finally brings the machines down.
+func (d *Data) drainMesos(machines []mesosMachine) error {
+
+	if d.MesosEndpoint == "" {
+		return errors.New("No Mesos master found, use --mesos-endpoint")
+	}
+
+	// Append a window to the current schedule:
+	schedule := mesosSchedule{}
+	if _, err := httpJSON("GET", d.MesosEndpoint+"/master/maintenance/schedule", nil, &schedule); err != nil {
+		return err
+	}
+
+	window, err := json.Marshal(map[string]interface{}{
+		"machine_ids": machines,
+		"unavailability": map[string]interface{}{
+			"start": map[string]int64{"nanoseconds": time.Now().UnixNano()},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	schedule.Windows = append(schedule.Windows, window)
+	if _, err := httpJSON("POST", d.MesosEndpoint+"/master/maintenance/schedule", schedule, nil); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": machines[0].Hostname}).
+		Info("Mesos maintenance scheduled, draining")
+
+	// Wait for the agents to run out of tasks:
+	for deadline := time.Now().Add(d.DrainTimeout); ; time.Sleep(5 * time.Second) {
+
+		agents := struct {
+			Slaves []struct {
+				Hostname      string `json:"hostname"`
+				UsedResources struct {
+					Cpus float64 `json:"cpus"`
+				} `json:"used_resources"`
+			} `json:"slaves"`
+		}{}
+
+		if _, err := httpJSON("GET", d.MesosEndpoint+"/master/slaves", nil, &agents); err != nil {
+			return err
+		}
+
+		busy := false
+		for _, a := range agents.Slaves {
+			for _, m := range machines {
+				if a.Hostname == m.Hostname && a.UsedResources.Cpus > 0 {
+					busy = true
+				}
+			}
+		}
+
+		if !busy {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": machines[0].Hostname}).
+				Warning("Drain timeout reached, remaining tasks will be killed")
+			break
+		}
+	}
+
+	// Bring the machines down:
+	if _, err := httpJSON("POST", d.MesosEndpoint+"/master/machine/down", machines, nil); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": machines[0].Hostname}).
+		Info("Mesos machine down")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: removeEtcdMember
+//-----------------------------------------------------------------------------
+
+// removeEtcdMember removes the etcd member with the given name.
+func (d *Data) removeEtcdMember(name string) error {
+	return d.removeEtcd(name, func(mName string, _ []string) bool {
+		return mName == name
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: removeEtcdPeer
+//-----------------------------------------------------------------------------
+
+// removeEtcdPeer removes the etcd member with the given peer URL, members
+// added but not started yet have no name.
+func (d *Data) removeEtcdPeer(peerURL string) error {
+	return d.removeEtcd(peerURL, func(_ string, peerURLs []string) bool {
+		for _, u := range peerURLs {
+			if u == peerURL {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: removeEtcd
+//-----------------------------------------------------------------------------
+
+func (d *Data) removeEtcd(what string, match func(name string, peerURLs []string) bool) error {
+
+	if d.EtcdEndpoint == "" {
+		return errors.New("No etcd member found, use --etcd-endpoint")
+	}
+
+	// Find the member ID:
+	members := struct {
+		Members []struct {
+			ID       string   `json:"id"`
+			Name     string   `json:"name"`
+			PeerURLs []string `json:"peerURLs"`
+		} `json:"members"`
+	}{}
+
+	if _, err := httpJSON("GET", d.EtcdEndpoint+"/v2/members", nil, &members); err != nil {
+		return err
+	}
+
+	for _, m := range members.Members {
+		if match(m.Name, m.PeerURLs) {
+
+			// Send the member removal request:
+			if _, err := httpJSON("DELETE", d.EtcdEndpoint+"/v2/members/"+m.ID, nil, nil); err != nil {
+				return err
+			}
+
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": m.ID}).
+				Info("Member " + what + " removed from etcd")
+			return nil
+		}
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": what}).
+		Info("Not an etcd member")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: removeEtcdKey
+//-----------------------------------------------------------------------------
+
+func (d *Data) removeEtcdKey(key string) error {
+
+	// Send the key removal request:
+	status, err := httpJSON("DELETE", d.EtcdEndpoint+"/v2/keys"+key, nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": key}).
+		Info("Etcd key removed")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: httpJSON
+//-----------------------------------------------------------------------------
+
+// httpJSON sends in as JSON (if not nil) and decodes the response into out
+// (if not nil). Non 2xx responses are errors.
+func httpJSON(method, url string, in, out interface{}) (int, error) {
+
+	// Encode the request body:
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	// Forge the request:
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, err
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Send the request (redirects lead to the leading master):
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	// Check the status:
+	if res.StatusCode/100 != 2 {
+		raw, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, errors.New(method + " " + url + ": " +
+			res.Status + " " + strings.TrimSpace(string(raw)))
+	}
+
+	// Decode the response body:
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil && err != io.EOF {
+			return res.StatusCode, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,355 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/katosys/kato/pkg/cluster"
+)
+
+//-----------------------------------------------------------------------------
+// func: Replace
+//-----------------------------------------------------------------------------
+
+// Replace provisions a new instance for a quorum or master node with the same
+// host name and ID and waits for it to be healthy before the old instance is
+// terminated. Nothing is changed in the cluster until the new instance is up,
+// and a failed launch leaves the old node as it was.
+func (d *Data) Replace() {
+
+	// Set current command:
+	d.command = "replace"
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Load state from state file:
+	if err := d.loadState(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	d.setupAPIEndpoints()
+
+	// Locate the node and its peers:
+	old, peers, err := d.locateNode()
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.Node}).Fatal(err)
+	}
+
+	// Reach the private IPs through a border node:
+	d.setupJumpHost(peers)
+	defer d.closeTunnels()
+
+	roles := d.instanceRoles(old)
+	quorum, master := hasRole(roles, "quorum"), hasRole(roles, "master")
+	if !quorum && !master {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.Node}).
+			Fatal("Only quorum and master nodes can be replaced, use remove and add")
+	}
+
+	// Default to the endpoints of the peers:
+	if d.EtcdEndpoint == "" {
+		d.EtcdEndpoint = d.peerEndpoint(peers, "quorum", "2379")
+	}
+
+	if d.MesosEndpoint == "" {
+		d.MesosEndpoint = d.mesosLeader(d.peerEndpoint(peers, "master", "5050"))
+	}
+
+	// The masters must have a leader:
+	if master {
+		if err := d.checkMesosLeader(d.MesosEndpoint); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		}
+	}
+
+	// The new node:
+	name, c := tagName(old), d.clusterData()
+	n := &cluster.Node{
+		HostName:     strings.TrimSuffix(name, "-"+d.hostID(old)+"."+d.Domain),
+		HostID:       d.hostID(old),
+		Roles:        roles,
+		InstanceType: *old.InstanceType,
+		ClusterState: "existing",
+	}
+
+	if quorum {
+		d.replaceQuorum(c, old, n)
+	} else {
+		d.replaceMaster(c, old, n)
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": name}).
+		Info("Node replaced")
+}
+
+//-----------------------------------------------------------------------------
+// func: replaceQuorum
+//-----------------------------------------------------------------------------
+
+// replaceQuorum launches the new quorum node and then swaps the etcd members.
+// etcd only lets a node join a cluster with as many members as its initial
+// cluster, so the new member is added right before the old one is removed
+// and either step is rolled back on failure. The DNS records move at once so
+// the ZooKeeper ensemble finds the new server.
+func (d *Data) replaceQuorum(c *cluster.Cluster, old *ec2.Instance, n *cluster.Node) {
+
+	ips := d.launch(c, n)
+	oldURL, newURL := "http://"+*old.PrivateIpAddress+":2380", "http://"+ips.Internal+":2380"
+
+	// Swap the etcd members:
+	if err := d.addEtcdMember(newURL); err != nil {
+		d.abandon(n, err)
+	}
+
+	if err := d.removeEtcdPeer(oldURL); err != nil {
+		if rerr := d.removeEtcdPeer(newURL); rerr != nil {
+			log.WithField("cmd", "ec2:"+d.command).Error(rerr)
+		}
+		d.abandon(n, err)
+	}
+
+	d.moveDNSRecords(c, n, ips)
+
+	// Past the swap the old member can't rejoin, the old instance is kept:
+	if err := d.waitFor("etcd member", func() bool {
+		_, err := httpJSON("GET", "http://"+d.reach(ips.Internal, "2379")+"/health", nil, nil)
+		return err == nil
+	}); err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": *old.InstanceId}).
+			Fatal(err.Error() + ", the old instance is no longer an etcd member, see 'Recover quorum nodes'")
+	}
+
+	if err := d.waitFor("ZooKeeper server", func() bool {
+		return zkServing(d.reach(ips.Internal, "2181"))
+	}); err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": *old.InstanceId}).
+			Fatal(err.Error() + ", the old instance is no longer an etcd member, see 'Recover quorum nodes'")
+	}
+
+	// The new instance is healthy, terminate the old one:
+	if err := d.terminateInstances([]*ec2.Instance{old}); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: replaceMaster
+//-----------------------------------------------------------------------------
+
+// replaceMaster swaps the master twice to keep its reserved private IP, which
+// the old instance holds until it is gone: a temporary master is launched on
+// a fresh IP and takes over, then the final one is launched on the reserved
+// IP and takes over from the temporary one. The DNS records follow each swap.
+func (d *Data) replaceMaster(c *cluster.Cluster, old *ec2.Instance, n *cluster.Node) {
+
+	// Temporary master:
+	tmp := *n
+	d.freshIP = true
+	tmpIPs := d.launch(c, &tmp)
+	d.freshIP = false
+
+	if err := d.waitMesosMaster(tmpIPs.Internal); err != nil {
+		d.abandon(&tmp, err)
+	}
+
+	if err := d.terminateInstances([]*ec2.Instance{old}); err != nil {
+		d.abandon(&tmp, err)
+	}
+
+	d.moveDNSRecords(c, &tmp, tmpIPs)
+
+	// Final master on the reserved IP, the temporary one is kept on failure:
+	ips, err := cluster.LaunchNode(d, c, n)
+	if err == nil {
+		err = d.waitMesosMaster(ips.Internal)
+	}
+
+	if err != nil {
+		if n.ID != "" {
+			if terr := d.TerminateInstance(n); terr != nil {
+				log.WithField("cmd", "ec2:"+d.command).Error(terr)
+			}
+		}
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": tmp.ID}).
+			Fatal(err.Error() + ", the node runs on " + tmpIPs.Internal +
+				" instead of its reserved IP, replace it again")
+	}
+
+	if err := d.TerminateInstance(&tmp); err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": tmp.ID}).Fatal(err)
+	}
+
+	d.moveDNSRecords(c, n, ips)
+}
+
+//-----------------------------------------------------------------------------
+// func: launch
+//-----------------------------------------------------------------------------
+
+// launch provisions the new instance of n, it exits on failure without
+// leaving the instance behind.
+func (d *Data) launch(c *cluster.Cluster, n *cluster.Node) *cluster.IPs {
+	ips, err := cluster.LaunchNode(d, c, n)
+	if err != nil {
+		d.abandon(n, err)
+	}
+	return ips
+}
+
+//-----------------------------------------------------------------------------
+// func: abandon
+//-----------------------------------------------------------------------------
+
+// abandon terminates the new instance of n, if any, and exits with err.
+func (d *Data) abandon(n *cluster.Node, err error) {
+
+	if n.ID != "" {
+		if terr := d.TerminateInstance(n); terr != nil {
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": n.ID}).Error(terr)
+		}
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.Node}).
+		Fatal(err.Error() + ", the node was not replaced")
+}
+
+//-----------------------------------------------------------------------------
+// func: waitMesosMaster
+//-----------------------------------------------------------------------------
+
+func (d *Data) waitMesosMaster(ip string) error {
+	return d.waitFor("Mesos master", func() bool {
+		return d.checkMesosLeader("http://"+d.reach(ip, "5050")) == nil
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: moveDNSRecords
+//-----------------------------------------------------------------------------
+
+// moveDNSRecords points the records of the node at its new instance, failures
+// are only reported.
+func (d *Data) moveDNSRecords(c *cluster.Cluster, n *cluster.Node, ips *cluster.IPs) {
+
+	if d.DNSProvider == "" || d.DNSProvider == "none" {
+		return
+	}
+
+	if err := d.deleteDNSRecords(n.Roles, n.HostID); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Warning(err)
+	}
+
+	if err := cluster.PublishDNSRecords(c, n, ips); err != nil {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": n.FQDN(d.Domain)}).
+			Warning(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: waitFor
+//-----------------------------------------------------------------------------
+
+// waitFor polls ready until it returns true or the timeout expires.
+func (d *Data) waitFor(what string, ready func() bool) error {
+
+	log.WithField("cmd", "ec2:"+d.command).Info("Waiting for the " + what)
+
+	for deadline := time.Now().Add(d.Timeout); !ready(); time.Sleep(10 * time.Second) {
+		if time.Now().After(deadline) {
+			return errors.New("Timeout waiting for the " + what)
+		}
+	}
+
+	log.WithField("cmd", "ec2:"+d.command).Info("The " + what + " is healthy")
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: addEtcdMember
+//-----------------------------------------------------------------------------
+
+func (d *Data) addEtcdMember(peerURL string) error {
+
+	if d.EtcdEndpoint == "" {
+		return errors.New("No etcd member found, use --etcd-endpoint")
+	}
+
+	// Send the member addition request:
+	if _, err := httpJSON("POST", d.EtcdEndpoint+"/v2/members", map[string][]string{
+		"peerURLs": {peerURL},
+	}, nil); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": peerURL}).
+		Info("New member added to etcd")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: checkMesosLeader
+//-----------------------------------------------------------------------------
+
+// checkMesosLeader succeeds if the master at endpoint knows the leader.
+func (d *Data) checkMesosLeader(endpoint string) error {
+
+	if endpoint == "" {
+		return errors.New("No Mesos master found, use --mesos-endpoint")
+	}
+
+	state := struct {
+		Leader string `json:"leader"`
+	}{}
+
+	if _, err := httpJSON("GET", endpoint+"/master/state", nil, &state); err != nil {
+		return err
+	}
+
+	if state.Leader == "" {
+		return errors.New("No Mesos leader elected")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: zkServing
+//-----------------------------------------------------------------------------
+
+// zkServing tells whether the ZooKeeper server at addr has joined the ensemble.
+func zkServing(addr string) bool {
+
+	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Four letter word command:
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("srvr")); err != nil {
+		return false
+	}
+
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(out), "Mode: follower") ||
+		strings.Contains(string(out), "Mode: leader")
+}
@@ -23,13 +23,15 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/katosys/kato/pkg/cluster"
 )
 
 //-----------------------------------------------------------------------------
 // func: Run
 //-----------------------------------------------------------------------------
 
-// Run uses EC2 API to launch a new instance.
+// Run uses EC2 API to launch a new instance with the user data read from
+// stdin and prints its IP addresses to stdout.
 func (d *Data) Run() {
 
 	// Set current command:
@@ -41,38 +43,62 @@ func (d *Data) Run() {
 		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
 	}
 
+	// Run the EC2 instance:
+	ips, err := d.Launch(udata)
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	// Output IP addresses to stdout:
+	jsn, err := json.Marshal(ips)
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	fmt.Println(string(jsn))
+}
+
+//-----------------------------------------------------------------------------
+// func: Launch
+//-----------------------------------------------------------------------------
+
+// Launch uses EC2 API to launch a new instance with the given user data and
+// returns its IP addresses.
+func (d *Data) Launch(udata []byte) (*cluster.IPs, error) {
+
+	// Set current command:
+	d.command = "run"
+
 	// Connect and authenticate to the API endpoints:
 	d.ec2 = ec2.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
 	d.elb = elb.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
 
 	// Run the EC2 instance:
 	if err := d.runInstance(udata); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		return nil, err
 	}
 
 	// Modify instance attributes:
 	if err := d.modifyInstanceAttribute(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		return nil, err
 	}
 
 	// Setup an elastic IP:
 	if d.PublicIP == "elastic" {
 		if err := d.setupElasticIP(); err != nil {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			return nil, err
 		}
 	}
 
 	// Register with ELB:
 	if d.ELBName != "" {
 		if err := d.registerWithELB(); err != nil {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			return nil, err
 		}
 	}
 
-	// Output IP addresses to stdout:
-	if err := d.stdoutIPs(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Warning(err)
-	}
+	// Retrieve the IP addresses:
+	return d.instanceIPs()
 }
 
 //-----------------------------------------------------------------------------
@@ -286,18 +312,20 @@ func (d *Data) registerWithELB() error {
 }
 
 //-----------------------------------------------------------------------------
-// func: stdoutIPs
+// func: instanceIPs
 //-----------------------------------------------------------------------------
 
-func (d *Data) stdoutIPs() error {
+func (d *Data) instanceIPs() (*cluster.IPs, error) {
 
-	// Map to store the output:
-	m := make(map[string]string)
+	ips := &cluster.IPs{}
 
 	// Forge the describe request:
 	params := &ec2.DescribeNetworkInterfacesInput{
-		NetworkInterfaceIds: []*string{
-			aws.String(d.InterfaceID),
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("attachment.instance-id"),
+				Values: []*string{aws.String(d.InstanceID)},
+			},
 		},
 	}
 
@@ -307,7 +335,7 @@ func (d *Data) stdoutIPs() error {
 		// Send the describe request:
 		resp, err := d.ec2.DescribeNetworkInterfaces(params)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Extract data from response:
@@ -315,19 +343,19 @@ func (d *Data) stdoutIPs() error {
 
 			// Internal IP address:
 			if resp.NetworkInterfaces[0].PrivateIpAddresses[0].PrivateIpAddress != nil {
-				m["internal"] = *resp.NetworkInterfaces[0].PrivateIpAddresses[0].PrivateIpAddress
+				ips.Internal = *resp.NetworkInterfaces[0].PrivateIpAddresses[0].PrivateIpAddress
 			}
 
 			// External IP address:
 			if resp.NetworkInterfaces[0].PrivateIpAddresses[0].Association != nil {
 				if resp.NetworkInterfaces[0].PrivateIpAddresses[0].Association.PublicIp != nil {
-					m["external"] = *resp.NetworkInterfaces[0].PrivateIpAddresses[0].Association.PublicIp
+					ips.External = *resp.NetworkInterfaces[0].PrivateIpAddresses[0].Association.PublicIp
 				}
 			}
 		}
 
 		// Sleep and try again:
-		if m["internal"] == "" {
+		if ips.Internal == "" {
 			time.Sleep(2 * time.Second)
 			continue
 		}
@@ -335,13 +363,5 @@ func (d *Data) stdoutIPs() error {
 		break
 	}
 
-	// JSON encode:
-	jsn, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
-
-	// Print and return:
-	fmt.Println(string(jsn))
-	return nil
+	return ips, nil
 }
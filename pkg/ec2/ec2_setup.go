@@ -7,9 +7,7 @@ package ec2
 import (
 
 	// Stdlib:
-	"os"
 	"strings"
-	"sync"
 	"time"
 
 	// Community:
@@ -20,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/katosys/kato/pkg/cluster"
 	"github.com/katosys/kato/pkg/kato"
 )
 
@@ -34,37 +33,49 @@ func (d *Data) Setup() {
 	d.command = "setup"
 	d.setupAPIEndpoints()
 
-	// Load state from state file (if any):
-	if err := d.loadState(); err != nil {
-		if !strings.Contains(err.Error(), "no such file or directory") {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	// Print the plan and bail out:
+	if d.DryRun {
+		if err := d.loadState(); err != nil {
+			if !strings.Contains(err.Error(), "no such file or directory") {
+				log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			}
 		}
+		p := plan{}
+		d.planSetup(&p)
+		p.print()
+		return
 	}
 
-	// Create the VPC:
-	if err := d.createVPC(); err != nil {
+	// Keep other operators out:
+	defer d.lockState()()
+
+	if err := d.setup(); err != nil {
 		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
 	}
+}
 
-	// Setup a wait group:
-	var wg sync.WaitGroup
+//-----------------------------------------------------------------------------
+// func: setup
+//-----------------------------------------------------------------------------
 
-	// Setup VPC and IAM:
-	wg.Add(2)
-	go d.setupVPCNetwork(&wg)
-	go d.setupIAMSecurity(&wg)
-	wg.Wait()
+// setup creates the missing components and dumps the state. The caller
+// holds the state lock and has set up the API endpoints.
+func (d *Data) setup() error {
 
-	// Setup the EC2 and ELB:
-	wg.Add(2)
-	go d.setupEC2Firewall(&wg)
-	go d.setupEC2Balancer(&wg)
-	wg.Wait()
+	// Load state from state file (if any):
+	if err := d.loadState(); err != nil {
+		if !strings.Contains(err.Error(), "no such file or directory") {
+			return err
+		}
+	}
 
-	// Dump state to file:
-	if err := kato.DumpState(d.State, d.ClusterID); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	// Setup the network, the firewall and the balancer:
+	if err := cluster.Setup(d); err != nil {
+		return err
 	}
+
+	// Dump state to file:
+	return d.dumpState()
 }
 
 //-----------------------------------------------------------------------------
@@ -83,6 +94,30 @@ func (d *Data) setupAPIEndpoints() {
 	d.elb = elb.New(session.New(&aws.Config{Region: aws.String(d.Region)}))
 }
 
+//-----------------------------------------------------------------------------
+// func: SetupNetwork
+//-----------------------------------------------------------------------------
+
+// SetupNetwork creates the VPC, then its network and the IAM components.
+func (d *Data) SetupNetwork() error {
+
+	// Create the VPC:
+	if err := d.createVPC(); err != nil {
+		return err
+	}
+
+	// Private DNS zones need the VPC resolver:
+	if err := d.enableVPCDNS(); err != nil {
+		return err
+	}
+
+	// Setup VPC and IAM:
+	wch := kato.NewWaitChan(2)
+	go d.setupVPCNetwork(wch)
+	go d.setupIAMSecurity(wch)
+	return wch.WaitErr()
+}
+
 //-----------------------------------------------------------------------------
 // func: createVPC
 //-----------------------------------------------------------------------------
@@ -130,65 +165,94 @@ func (d *Data) createVPC() error {
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: enableVPCDNS
+//-----------------------------------------------------------------------------
+
+func (d *Data) enableVPCDNS() error {
+
+	// One attribute per request:
+	for _, params := range []*ec2.ModifyVpcAttributeInput{
+		{VpcId: aws.String(d.VpcID), EnableDnsSupport: &ec2.AttributeBooleanValue{Value: aws.Bool(true)}},
+		{VpcId: aws.String(d.VpcID), EnableDnsHostnames: &ec2.AttributeBooleanValue{Value: aws.Bool(true)}},
+	} {
+		if _, err := d.ec2.ModifyVpcAttribute(params); err != nil {
+			log.WithField("cmd", "ec2:"+d.command).Error(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
 //-----------------------------------------------------------------------------
 // func: setupVPCNetwork
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupVPCNetwork(wg *sync.WaitGroup) {
+func (d *Data) setupVPCNetwork(wch *kato.WaitChan) {
 
 	// Decrement:
-	defer wg.Done()
+	defer wch.WaitGrp.Done()
 
 	// Retrieve the main route table ID:
 	if err := d.retrieveMainRouteTableID(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create the external and internal subnets:
 	if err := d.createSubnets(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create a route table (ext):
 	if err := d.createRouteTable(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Associate the route table to the external subnet:
 	if err := d.associateRouteTable(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create the internet gateway:
 	if err := d.createInternetGateway(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Attach internet gateway to VPC:
 	if err := d.attachInternetGateway(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create a default route via internet GW (ext):
 	if err := d.createInternetGatewayRoute(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Allocate a new elastic IP:
 	if err := d.allocateElasticIP(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	if d.IntSubnetCidr != "" {
 
 		// Create a NAT gateway:
 		if err := d.createNatGateway(); err != nil {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			wch.ErrChan <- err
+			return
 		}
 
 		// Create a default route via NAT GW (int):
 		if err := d.createNatGatewayRoute(); err != nil {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			wch.ErrChan <- err
 		}
 	}
 }
@@ -543,23 +607,28 @@ func (d *Data) createNatGatewayRoute() error {
 // func: setupIAMSecurity
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupIAMSecurity(wg *sync.WaitGroup) {
+func (d *Data) setupIAMSecurity(wch *kato.WaitChan) {
 
 	// Decrement:
-	defer wg.Done()
+	defer wch.WaitGrp.Done()
 
 	// Create REX-Ray policy:
 	if err := d.createRexrayPolicy(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create IAM role:
 	if err := d.createIAMRole(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		wch.ErrChan <- err
+		return
 	}
 
 	// Create instance profile:
-	d.createInstanceProfile()
+	if err := d.createInstanceProfile(); err != nil {
+		wch.ErrChan <- err
+		return
+	}
 
 	// Attach policies to IAM role:
 	for _, policy := range [3]string{
@@ -568,13 +637,14 @@ func (d *Data) setupIAMSecurity(wg *sync.WaitGroup) {
 		d.RexrayPolicy,
 	} {
 		if err := d.attachPolicyToRole(policy, "kato"); err != nil {
-			log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+			wch.ErrChan <- err
+			return
 		}
 	}
 
 	// Add IAM role to instance profile:
 	if err := d.addIAMRoleToInstanceProfile(); err != nil {
-		os.Exit(1)
+		wch.ErrChan <- err
 	}
 }
 
@@ -742,7 +812,7 @@ func (d *Data) createIAMRole() error {
 // func: createInstanceProfile
 //-----------------------------------------------------------------------------
 
-func (d *Data) createInstanceProfile() {
+func (d *Data) createInstanceProfile() error {
 
 	// Forge the profile request:
 	params := &iam.CreateInstanceProfileInput{
@@ -755,10 +825,11 @@ func (d *Data) createInstanceProfile() {
 	if err != nil {
 		if reqErr, ok := err.(awserr.RequestFailure); ok {
 			if reqErr.StatusCode() == 409 {
-				return
+				return nil
 			}
 		}
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
 	}
 
 	// Wait until the instance profile exists:
@@ -769,8 +840,11 @@ func (d *Data) createInstanceProfile() {
 		&iam.GetInstanceProfileInput{
 			InstanceProfileName: aws.String("kato"),
 		}); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		log.WithField("cmd", "ec2:"+d.command).Error(err)
+		return err
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
@@ -804,53 +878,33 @@ func (d *Data) addIAMRoleToInstanceProfile() error {
 }
 
 //-----------------------------------------------------------------------------
-// func: setupEC2Firewall
+// func: SetupFirewall
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupEC2Firewall(wg *sync.WaitGroup) {
-
-	// Decrement:
-	defer wg.Done()
-
-	// Create quorum security group:
-	if err := d.createSecurityGroup("quorum", &d.QuorumSecGrp); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
-
-	// Create master security group:
-	if err := d.createSecurityGroup("master", &d.MasterSecGrp); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
+// SetupFirewall creates one security group per role and its rules.
+func (d *Data) SetupFirewall() error {
 
-	// Create worker security group:
-	if err := d.createSecurityGroup("worker", &d.WorkerSecGrp); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	// Create one security group per role:
+	if d.SecGrps == nil {
+		d.SecGrps = map[string]string{}
 	}
 
-	// Create border security group:
-	if err := d.createSecurityGroup("border", &d.BorderSecGrp); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
-
-	// Setup quorum nodes firewall:
-	if err := d.firewallQuorum(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
-
-	// Setup master nodes firewall:
-	if err := d.firewallMaster(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	for _, role := range kato.Roles {
+		id := d.SecGrps[role.Name]
+		if err := d.createSecurityGroup(role.Name, &id); err != nil {
+			return err
+		}
+		d.SecGrps[role.Name] = id
 	}
 
-	// Setup worker nodes firewall:
-	if err := d.firewallWorker(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	// Setup the firewall of every role:
+	for _, role := range kato.Roles {
+		if err := d.firewallRole(role); err != nil {
+			return err
+		}
 	}
 
-	// Setup border nodes firewall:
-	if err := d.firewallBorder(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
+	return nil
 }
 
 //-----------------------------------------------------------------------------
@@ -894,280 +948,93 @@ func (d *Data) createSecurityGroup(name string, id *string) error {
 }
 
 //-----------------------------------------------------------------------------
-// func: firewallQuorum
+// func: firewallRole
 //-----------------------------------------------------------------------------
 
-func (d *Data) firewallQuorum() error {
-
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.QuorumSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.QuorumSecGrp),
-					},
-					{
-						GroupId: aws.String(d.MasterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.WorkerSecGrp),
-					},
-					{
-						GroupId: aws.String(d.BorderSecGrp),
-					},
-				},
-			},
-		},
-	}
-
-	// Send the rule request:
-	if _, err := d.ec2.AuthorizeSecurityGroupIngress(params); err != nil {
-		ec2err, ok := err.(awserr.Error)
-		if ok && strings.Contains(ec2err.Code(), ".Duplicate") {
-			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "quorum"}).
-				Info("Using existing firewall rules")
-			return nil
-		}
-		log.WithField("cmd", "ec2:"+d.command).Error(err)
-		return err
-	}
-
-	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "quorum"}).
-		Info("New firewall rules defined")
-
-	return nil
-}
-
-//-----------------------------------------------------------------------------
-// func: firewallMaster
-//-----------------------------------------------------------------------------
-
-func (d *Data) firewallMaster() error {
-
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.MasterSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.QuorumSecGrp),
-					},
-					{
-						GroupId: aws.String(d.MasterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.WorkerSecGrp),
-					},
-					{
-						GroupId: aws.String(d.BorderSecGrp),
-					},
-				},
-			},
-		},
-	}
-
-	// Send the rule request:
-	if _, err := d.ec2.AuthorizeSecurityGroupIngress(params); err != nil {
-		ec2err, ok := err.(awserr.Error)
-		if ok && strings.Contains(ec2err.Code(), ".Duplicate") {
-			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "master"}).
-				Info("Using existing firewall rules")
-			return nil
-		}
-		log.WithField("cmd", "ec2:"+d.command).Error(err)
-		return err
-	}
+func (d *Data) firewallRole(role kato.Role) error {
 
-	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "master"}).
-		Info("New firewall rules defined")
-
-	return nil
-}
+	perms := []*ec2.IpPermission{}
 
-//-----------------------------------------------------------------------------
-// func: firewallWorker
-//-----------------------------------------------------------------------------
-
-func (d *Data) firewallWorker() error {
-
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.WorkerSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.QuorumSecGrp),
-					},
-					{
-						GroupId: aws.String(d.MasterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.WorkerSecGrp),
-					},
-					{
-						GroupId: aws.String(d.BorderSecGrp),
-					},
+	// Allow all traffic from every role:
+	for _, r := range kato.Roles {
+		perms = append(perms, &ec2.IpPermission{
+			IpProtocol: aws.String("-1"),
+			UserIdGroupPairs: []*ec2.UserIdGroupPair{
+				{
+					GroupId: aws.String(d.SecGrps[r.Name]),
 				},
 			},
-			{
-				FromPort:   aws.Int64(80),
-				ToPort:     aws.Int64(80),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(443),
-				ToPort:     aws.Int64(443),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
+		})
+	}
+
+	// Role specific public ingress:
+	for _, in := range role.Ingress {
+		perms = append(perms, &ec2.IpPermission{
+			FromPort:   aws.Int64(int64(in.FromPort)),
+			ToPort:     aws.Int64(int64(in.ToPort)),
+			IpProtocol: aws.String(in.Protocol),
+			IpRanges: []*ec2.IpRange{
+				{
+					CidrIp: aws.String(in.CidrIP),
 				},
 			},
-		},
+		})
 	}
 
-	// Send the rule request:
-	if _, err := d.ec2.AuthorizeSecurityGroupIngress(params); err != nil {
-		ec2err, ok := err.(awserr.Error)
-		if ok && strings.Contains(ec2err.Code(), ".Duplicate") {
-			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "worker"}).
-				Info("Using existing firewall rules")
-			return nil
-		}
-		log.WithField("cmd", "ec2:"+d.command).Error(err)
-		return err
-	}
-
-	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "worker"}).
-		Info("New firewall rules defined")
+	// One rule request per permission so new roles can join existing groups:
+	created := 0
+	for _, perm := range perms {
 
-	return nil
-}
-
-//-----------------------------------------------------------------------------
-// func: firewallBorder
-//-----------------------------------------------------------------------------
+		// Forge the rule request:
+		params := &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(d.SecGrps[role.Name]),
+			IpPermissions: []*ec2.IpPermission{perm},
+		}
 
-func (d *Data) firewallBorder() error {
+		// Send the rule request:
+		if _, err := d.ec2.AuthorizeSecurityGroupIngress(params); err != nil {
+			ec2err, ok := err.(awserr.Error)
+			if ok && strings.Contains(ec2err.Code(), ".Duplicate") {
+				continue
+			}
+			log.WithField("cmd", "ec2:"+d.command).Error(err)
+			return err
+		}
 
-	// Forge the rule request:
-	params := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: aws.String(d.BorderSecGrp),
-		IpPermissions: []*ec2.IpPermission{
-			{
-				IpProtocol: aws.String("-1"),
-				UserIdGroupPairs: []*ec2.UserIdGroupPair{
-					{
-						GroupId: aws.String(d.QuorumSecGrp),
-					},
-					{
-						GroupId: aws.String(d.MasterSecGrp),
-					},
-					{
-						GroupId: aws.String(d.WorkerSecGrp),
-					},
-					{
-						GroupId: aws.String(d.BorderSecGrp),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(22),
-				ToPort:     aws.Int64(22),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(80),
-				ToPort:     aws.Int64(80),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(443),
-				ToPort:     aws.Int64(443),
-				IpProtocol: aws.String("tcp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				FromPort:   aws.Int64(18443),
-				ToPort:     aws.Int64(18443),
-				IpProtocol: aws.String("udp"),
-				IpRanges: []*ec2.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-		},
+		created++
 	}
 
-	// Send the rule request:
-	if _, err := d.ec2.AuthorizeSecurityGroupIngress(params); err != nil {
-		ec2err, ok := err.(awserr.Error)
-		if ok && strings.Contains(ec2err.Code(), ".Duplicate") {
-			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "border"}).
-				Info("Using existing firewall rules")
-			return nil
-		}
-		log.WithField("cmd", "ec2:"+d.command).Error(err)
-		return err
+	if created == 0 {
+		log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": role.Name}).
+			Info("Using existing firewall rules")
+		return nil
 	}
 
-	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": "border"}).
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": role.Name}).
 		Info("New firewall rules defined")
 
 	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: setupEC2Balancer
+// func: SetupBalancer
 //-----------------------------------------------------------------------------
 
-func (d *Data) setupEC2Balancer(wg *sync.WaitGroup) {
-
-	// Decrement:
-	defer wg.Done()
+// SetupBalancer creates the ELB and its security group.
+func (d *Data) SetupBalancer() error {
 
 	// Create the ELB security group:
 	if err := d.createSecurityGroup("elb", &d.ELBSecGrp); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		return err
 	}
 
 	// Create the ELB:
 	if err := d.createELB(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+		return err
 	}
 
 	// Setup the ELB firewall:
-	if err := d.firewallELB(); err != nil {
-		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
-	}
+	return d.firewallELB()
 }
 
 //-----------------------------------------------------------------------------
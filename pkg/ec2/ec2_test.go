@@ -1 +1,186 @@
 package ec2
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestMigrateState
+//-----------------------------------------------------------------------------
+
+func TestMigrateState(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		raw     string
+		from    int
+		applied int
+		want    string
+		fail    bool
+	}{
+		{
+			name: "v0 with per role security groups",
+			raw: `{"Domain": "example.com", "DNSProvider": "r53",
+				"QuorumSecGrp": "sg-1", "MasterSecGrp": "sg-2", "WorkerSecGrp": "",
+				"SlackWebhook:": "https://hooks", "AdminEmail:": "ops@example.com"}`,
+			from:    0,
+			applied: 3,
+			want: `{"Version": 3, "Domain": "example.com", "DNSProvider": "r53",
+				"SecGrps": {"quorum": "sg-1", "master": "sg-2"},
+				"SlackWebhook": "https://hooks", "AdminEmail": "ops@example.com",
+				"DNSZones": ["int.example.com", "ext.example.com"]}`,
+		},
+		{
+			name: "v0 keeps the SecGrps already set",
+			raw: `{"Domain": "example.com", "DNSProvider": "none",
+				"SecGrps": {"quorum": "sg-9"}, "QuorumSecGrp": "sg-1", "BorderSecGrp": "sg-4"}`,
+			from:    0,
+			applied: 3,
+			want: `{"Version": 3, "Domain": "example.com", "DNSProvider": "none",
+				"SecGrps": {"quorum": "sg-9", "border": "sg-4"}, "DNSZones": []}`,
+		},
+		{
+			name:    "v1 renames the colon tags",
+			raw:     `{"Version": 1, "SMTPURL:": "smtp://u:p@h:25", "SMTPURL": "smtp://x:y@z:25"}`,
+			from:    1,
+			applied: 2,
+			want:    `{"Version": 3, "SMTPURL": "smtp://x:y@z:25", "DNSZones": []}`,
+		},
+		{
+			name:    "v2 keeps the recorded zones",
+			raw:     `{"Version": 2, "Domain": "example.com", "DNSProvider": "ns1", "DNSZones": []}`,
+			from:    2,
+			applied: 1,
+			want:    `{"Version": 3, "Domain": "example.com", "DNSProvider": "ns1", "DNSZones": []}`,
+		},
+		{
+			name: "current version is untouched",
+			raw:  `{"Version": 3, "Domain": "example.com"}`,
+			from: 3,
+			want: `{"Version": 3, "Domain": "example.com"}`,
+		},
+		{
+			name: "newer version",
+			raw:  `{"Version": 4}`,
+			from: 4,
+			fail: true,
+		},
+		{
+			name: "security group of the wrong type",
+			raw:  `{"QuorumSecGrp": 1}`,
+			fail: true,
+		},
+		{
+			name: "not JSON",
+			raw:  `Version: 1`,
+			fail: true,
+		},
+	}
+
+	for _, tt := range tests {
+
+		out, from, applied, err := MigrateState([]byte(tt.raw))
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if from != tt.from || len(applied) != tt.applied {
+			t.Errorf("%s: got version %d and %d migrations, want %d and %d",
+				tt.name, from, len(applied), tt.from, tt.applied)
+		}
+
+		var got, want map[string]interface{}
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %s", tt.name, out)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestMigrateStateDecodes
+//-----------------------------------------------------------------------------
+
+func TestMigrateStateDecodes(t *testing.T) {
+
+	out, _, _, err := MigrateState([]byte(`{"ClusterID": "c1", "Domain": "example.com",
+		"DNSProvider": "r53", "QuorumSecGrp": "sg-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := State{}
+	if err := json.Unmarshal(out, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ClusterID != "c1" || s.SecGrps["quorum"] != "sg-1" || len(s.DNSZones) != 2 {
+		t.Errorf("unexpected state: %+v", s)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestSpecDrift
+//-----------------------------------------------------------------------------
+
+func TestSpecDrift(t *testing.T) {
+
+	old := State{
+		Domain:       "example.com",
+		Region:       "eu-west-1",
+		DNSProvider:  "r53",
+		SlackWebhook: "https://hooks/1",
+		StubZones:    []string{"a.lan/10.0.0.2"},
+		Quadruplets:  []string{"3:m3.medium:quorum:quorum", "2:m3.large:worker:worker"},
+	}
+
+	tests := []struct {
+		name   string
+		change func(s *State)
+		fixed  []string
+		stale  []string
+	}{
+		{"same", func(s *State) {}, nil, nil},
+		{"domain", func(s *State) { s.Domain = "example.org" }, []string{"domain"}, nil},
+		{"dns provider", func(s *State) { s.DNSProvider = "ns1" }, []string{"dns.provider"}, nil},
+		{"missing in old state", func(s *State) { s.CalicoIPPool = "10.128.0.0/21" }, nil, nil},
+		{"alerting", func(s *State) { s.SlackWebhook, s.AdminEmail = "", "ops@example.com" },
+			nil, []string{"alerting.slackWebhook", "alerting.adminEmail"}},
+		{"stub zones", func(s *State) { s.StubZones = nil }, nil, []string{"features.stubZones"}},
+		{"pool type and roles", func(s *State) {
+			s.Quadruplets = []string{"3:m3.medium:quorum:quorum", "4:m3.xlarge:worker:worker,border"}
+		}, nil, []string{"pools.worker.instanceType", "pools.worker.roles"}},
+		{"pool resized or added", func(s *State) {
+			s.Quadruplets = []string{"1:m3.medium:quorum:quorum", "1:m3.large:border:border"}
+		}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		spec := old
+		spec.Quadruplets = append([]string{}, old.Quadruplets...)
+		tt.change(&spec)
+		fixed, stale := specDrift(&old, &spec)
+		stale = append(stale, poolDrift(old.Quadruplets, spec.Quadruplets)...)
+		if !reflect.DeepEqual(fixed, tt.fixed) || !reflect.DeepEqual(stale, tt.stale) {
+			t.Errorf("%s: got %q and %q, want %q and %q", tt.name, fixed, stale, tt.fixed, tt.stale)
+		}
+	}
+}
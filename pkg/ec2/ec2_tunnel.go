@@ -0,0 +1,166 @@
+package ec2
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Local SSH forward to a private host:port:
+type tunnel struct {
+	local string
+	cmd   *exec.Cmd
+}
+
+//-----------------------------------------------------------------------------
+// func: setupJumpHost
+//-----------------------------------------------------------------------------
+
+// setupJumpHost picks the SSH host the private IPs are reached through:
+// --jump-host or else the first running border node, the only role open to
+// SSH. With 'none' or without border nodes the private IPs are dialed as is,
+// which only works from within the VPC.
+func (d *Data) setupJumpHost(peers []*ec2.Instance) {
+
+	if d.JumpHost == "" {
+		for _, i := range peers {
+			if *i.State.Name == "running" && i.PublicIpAddress != nil &&
+				hasRole(d.instanceRoles(i), "border") {
+				d.JumpHost = *i.PublicIpAddress
+				break
+			}
+		}
+	}
+
+	if d.JumpHost == "" || d.JumpHost == "none" {
+		d.JumpHost = ""
+		log.WithField("cmd", "ec2:"+d.command).
+			Info("No jump host, the private IPs are dialed directly")
+		return
+	}
+
+	d.tunnels = map[string]*tunnel{}
+	log.RegisterExitHandler(d.closeTunnels)
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": d.JumpHost}).
+		Info("Tunneling the private IPs through " + d.SSHUser + "@" + d.JumpHost)
+}
+
+//-----------------------------------------------------------------------------
+// func: reach
+//-----------------------------------------------------------------------------
+
+// reach returns an address dialable from here for the private ip:port. The
+// SSH forward is opened on first use and kept until closeTunnels.
+func (d *Data) reach(ip, port string) string {
+
+	target := ip + ":" + port
+	if d.JumpHost == "" {
+		return target
+	}
+
+	if t, ok := d.tunnels[target]; ok {
+		return t.local
+	}
+
+	// Grab a free local port:
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	t := &tunnel{local: l.Addr().String()}
+	_ = l.Close()
+
+	// Forward it to the target:
+	t.cmd = exec.Command("ssh", "-N",
+		"-o", "BatchMode=yes",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-L", t.local+":"+target, d.SSHUser+"@"+d.JumpHost)
+	t.cmd.Stderr = os.Stderr
+
+	if err := t.cmd.Start(); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Fatal(err)
+	}
+
+	d.tunnels[target] = t
+
+	// Wait for the forward to listen:
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(500 * time.Millisecond) {
+
+		if conn, err := net.Dial("tcp", t.local); err == nil {
+			_ = conn.Close()
+			break
+		}
+
+		if time.Now().After(deadline) {
+			log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": target}).
+				Fatal("Timeout tunneling through " + d.JumpHost + ", use --jump-host")
+		}
+	}
+
+	log.WithFields(log.Fields{"cmd": "ec2:" + d.command, "id": target}).
+		Debug("Tunneled at " + t.local)
+
+	return t.local
+}
+
+//-----------------------------------------------------------------------------
+// func: mesosLeader
+//-----------------------------------------------------------------------------
+
+// mesosLeader returns the tunneled URL of the leading master known to the
+// master at endpoint. Non leading masters redirect to the private IP of the
+// leader, which is out of reach through a jump host.
+func (d *Data) mesosLeader(endpoint string) string {
+
+	if endpoint == "" || d.JumpHost == "" {
+		return endpoint
+	}
+
+	state := struct {
+		Leader string `json:"leader"`
+	}{}
+
+	if _, err := httpJSON("GET", endpoint+"/master/state", nil, &state); err != nil {
+		log.WithField("cmd", "ec2:"+d.command).Warning(err)
+		return endpoint
+	}
+
+	// The leader is given as master@<ip>:<port>:
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(state.Leader, "master@"))
+	if err != nil {
+		return endpoint
+	}
+
+	return "http://" + d.reach(host, port)
+}
+
+//-----------------------------------------------------------------------------
+// func: closeTunnels
+//-----------------------------------------------------------------------------
+
+// closeTunnels kills the SSH forwards, it's safe to call more than once.
+func (d *Data) closeTunnels() {
+	for target, t := range d.tunnels {
+		_ = t.cmd.Process.Kill()
+		_ = t.cmd.Wait()
+		delete(d.tunnels, target)
+	}
+}
@@ -7,13 +7,10 @@ package kato
 import (
 
 	// Stdlib:
-	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -58,56 +55,6 @@ func (wch *WaitChan) WaitErr() error {
 	}
 }
 
-//-----------------------------------------------------------------------------
-// func: DumpState
-//-----------------------------------------------------------------------------
-
-// DumpState serializes the given state as a clusterID JSON file.
-func DumpState(s interface{}, clusterID string) error {
-
-	// Marshal the data:
-	data, err := json.MarshalIndent(s, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	// Create the state directory:
-	path := os.Getenv("HOME") + "/.kato"
-	if _, err := os.Stat(path); err != nil {
-		if os.IsNotExist(err) {
-			err = os.Mkdir(path, 0700)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// Write the state file:
-	err = ioutil.WriteFile(path+"/"+clusterID+".json", data, 0600)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-//-----------------------------------------------------------------------------
-// func: ReadState
-//-----------------------------------------------------------------------------
-
-// ReadState reads the current ClusterID state file.
-func ReadState(clusterID string) ([]byte, error) {
-
-	// Read data from state file:
-	stateFile := os.Getenv("HOME") + "/.kato/" + clusterID + ".json"
-	raw, err := ioutil.ReadFile(stateFile)
-	if err != nil {
-		return nil, err
-	}
-
-	return raw, nil
-}
-
 //-----------------------------------------------------------------------------
 // func: CountNodes
 //-----------------------------------------------------------------------------
@@ -130,76 +77,6 @@ func CountNodes(quads []string, role string) (count int) {
 	return
 }
 
-//-----------------------------------------------------------------------------
-// func: CreateDNSZones
-//-----------------------------------------------------------------------------
-
-// CreateDNSZones creates (int|ext).<domain> zones using <provider>.
-func CreateDNSZones(wch *WaitChan, provider, apiKey, domain string) {
-
-	// Decrement:
-	defer wch.WaitGrp.Done()
-
-	// Forge the zone command:
-	cmd := exec.Command("katoctl", provider,
-		"--api-key", apiKey, "zone", "add",
-		domain, "int."+domain, "ext."+domain)
-
-	// Execute the zone command:
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		wch.ErrChan <- err
-	}
-}
-
-//-----------------------------------------------------------------------------
-// func: ExecutePipeline
-//-----------------------------------------------------------------------------
-
-// ExecutePipeline takes two commands and pipes the stdout of the first one
-// into the stdin of the second one. Returns the output as []byte.
-func ExecutePipeline(cmd1, cmd2 *exec.Cmd) ([]byte, error) {
-
-	var err error
-
-	// Adjust the stderr:
-	cmd1.Stderr = os.Stderr
-	cmd2.Stderr = os.Stderr
-
-	// Connect both commands:
-	cmd2.Stdin, err = cmd1.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	// Get cmd2 stdout:
-	stdout, err := cmd2.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	// Execute the pipeline:
-	if err = cmd2.Start(); err != nil {
-		return nil, err
-	}
-	if err = cmd1.Run(); err != nil {
-		return nil, err
-	}
-
-	// Read the cmd2 output:
-	out, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return nil, err
-	}
-
-	// Wait and return:
-	if err = cmd2.Wait(); err != nil {
-		return nil, err
-	}
-
-	return out, nil
-}
-
 //-----------------------------------------------------------------------------
 // func: NewEtcdToken
 //-----------------------------------------------------------------------------
@@ -0,0 +1,179 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	// Community:
+	"gopkg.in/yaml.v2"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Role describes a Káto role as seen by every subsystem.
+type Role struct {
+	Name     string    `yaml:"name"`     // Also the DNS name prefix.
+	Tags     []string  `yaml:"tags"`     // Extra udata fragment tags.
+	Services []string  `yaml:"services"` // Udata services.
+	Ingress  []Ingress `yaml:"ingress"`  // Public firewall rules.
+	ELB      bool      `yaml:"elb"`      // Register into the load balancer.
+}
+
+// Ingress is a public firewall rule.
+type Ingress struct {
+	FromPort int    `yaml:"fromPort"`
+	ToPort   int    `yaml:"toPort"`
+	Protocol string `yaml:"protocol"`
+	CidrIP   string `yaml:"cidrIP"`
+}
+
+//-----------------------------------------------------------------------------
+// Role registry:
+//-----------------------------------------------------------------------------
+
+// Roles is the role registry. Built-in roles come first.
+var Roles = []Role{
+
+	{
+		Name: "quorum",
+		Services: []string{
+			"docker", "rexray", "etchost", "zookeeper", "etcd-master", "rkt-api",
+			"cadvisor", "node-exporter", "zookeeper-exporter"},
+	},
+
+	{
+		Name: "master",
+		Services: []string{
+			"docker", "rexray", "etchost", "etcd-proxy", "calico", "mesos-dns",
+			"mesos-master", "marathon", "rkt-api", "cadvisor", "node-exporter",
+			"mesos-master-exporter", "confd", "alertmanager", "prometheus"},
+	},
+
+	{
+		Name: "worker",
+		Services: []string{
+			"docker", "rexray", "etchost", "etcd-proxy", "calico", "go-dnsmasq",
+			"marathon-lb", "mesos-agent", "rkt-api", "cadvisor", "node-exporter",
+			"mesos-agent-exporter", "haproxy-exporter"},
+		Ingress: []Ingress{
+			{FromPort: 80, ToPort: 80, Protocol: "tcp", CidrIP: "0.0.0.0/0"},
+			{FromPort: 443, ToPort: 443, Protocol: "tcp", CidrIP: "0.0.0.0/0"},
+		},
+		ELB: true,
+	},
+
+	{
+		Name: "border",
+		Services: []string{
+			"docker", "rexray", "etchost", "etcd-proxy", "calico", "mongodb",
+			"pritunl", "rkt-api", "cadvisor", "node-exporter"},
+		Ingress: []Ingress{
+			{FromPort: 22, ToPort: 22, Protocol: "tcp", CidrIP: "0.0.0.0/0"},
+			{FromPort: 80, ToPort: 80, Protocol: "tcp", CidrIP: "0.0.0.0/0"},
+			{FromPort: 443, ToPort: 443, Protocol: "tcp", CidrIP: "0.0.0.0/0"},
+			{FromPort: 18443, ToPort: 18443, Protocol: "udp", CidrIP: "0.0.0.0/0"},
+		},
+	},
+}
+
+// Tags with a meaning of their own that can't be used as role names:
+var reservedTags = []string{
+	"node", "vbox", "ec2", "pkt", "new", "existing", "cacert", "prometheus",
+	"secrets"}
+
+//-----------------------------------------------------------------------------
+// func: RoleNames
+//-----------------------------------------------------------------------------
+
+// RoleNames returns the names of all the registered roles.
+func RoleNames() (names []string) {
+	for _, role := range Roles {
+		names = append(names, role.Name)
+	}
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: GetRole
+//-----------------------------------------------------------------------------
+
+// GetRole returns the registered role with the given name.
+func GetRole(name string) (Role, bool) {
+	for _, role := range Roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return Role{}, false
+}
+
+//-----------------------------------------------------------------------------
+// func: LoadRoles
+//-----------------------------------------------------------------------------
+
+// LoadRoles reads custom roles from a YAML file into the registry. A role
+// with the name of a registered one replaces it. The file is read from
+// $KATO_ROLES_FILE or ~/.kato/roles.yaml and it is fine if neither exists.
+func LoadRoles() error {
+
+	// Locate the roles file:
+	path := os.Getenv("KATO_ROLES_FILE")
+	if path == "" {
+		path = os.Getenv("HOME") + "/.kato/roles.yaml"
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	// Read and decode the roles file:
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dat := struct {
+		Roles []Role `yaml:"roles"`
+	}{}
+
+	if err := yaml.Unmarshal(raw, &dat); err != nil {
+		return err
+	}
+
+	// Register the roles:
+	for _, role := range dat.Roles {
+
+		if match, _ := regexp.MatchString("^[a-z\\d-]+$", role.Name); !match {
+			return errors.New("Invalid role name: " + role.Name)
+		}
+
+		for _, tag := range reservedTags {
+			if role.Name == tag {
+				return errors.New("Reserved role name: " + role.Name)
+			}
+		}
+
+		registered := false
+		for i := range Roles {
+			if Roles[i].Name == role.Name {
+				Roles[i], registered = role, true
+				break
+			}
+		}
+
+		if !registered {
+			Roles = append(Roles, role)
+		}
+	}
+
+	return nil
+}
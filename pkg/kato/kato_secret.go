@@ -0,0 +1,293 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	// Community:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Sealed state envelope: the state is encrypted with a random data key which
+// is in turn encrypted with the key encryption key (KEK).
+type sealed struct {
+	Sealed int    `json:"Sealed"` // Envelope format version
+	KEK    string `json:"KEK"`    // passphrase | keyfile | kms
+	Salt   []byte `json:"Salt"`   // scrypt salt (passphrase)
+	Key    []byte `json:"Key"`    // Encrypted data key
+	Data   []byte `json:"Data"`   // Encrypted state
+}
+
+// A KEK wraps and unwraps data keys.
+type kek interface {
+	name() string
+	wrap(env *sealed, key *[32]byte) error
+	unwrap(env *sealed) (*[32]byte, error)
+}
+
+//-----------------------------------------------------------------------------
+// func: newKEK
+//-----------------------------------------------------------------------------
+
+// newKEK returns the KEK configured in the environment, if any:
+//
+//	KATO_STATE_PASSPHRASE  Passphrase stretched with scrypt
+//	KATO_STATE_KEY_FILE    File with a base64 encoded 32 bytes NaCl key
+//	KATO_STATE_KMS_KEY_ID  KMS key (KATO_STATE_KMS_ENDPOINT for a stand-in)
+func newKEK() (kek, error) {
+
+	switch {
+
+	case os.Getenv("KATO_STATE_PASSPHRASE") != "":
+		return &passphraseKEK{passphrase: os.Getenv("KATO_STATE_PASSPHRASE")}, nil
+
+	case os.Getenv("KATO_STATE_KEY_FILE") != "":
+		raw, err := ioutil.ReadFile(os.Getenv("KATO_STATE_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw)))
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("The state key file must hold 32 base64 encoded bytes")
+		}
+		k := &keyfileKEK{}
+		copy(k.key[:], key)
+		return k, nil
+
+	case os.Getenv("KATO_STATE_KMS_KEY_ID") != "":
+		cfg := &aws.Config{}
+		if endpoint := os.Getenv("KATO_STATE_KMS_ENDPOINT"); endpoint != "" {
+			cfg.Endpoint = aws.String(endpoint)
+		}
+		sess, err := session.NewSession(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &kmsKEK{kms: kms.New(sess), keyID: os.Getenv("KATO_STATE_KMS_KEY_ID")}, nil
+	}
+
+	return nil, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Seal
+//-----------------------------------------------------------------------------
+
+// Seal encrypts data if a state key is configured, else it's left as is.
+func Seal(data []byte) ([]byte, error) {
+
+	k, err := newKEK()
+	if err != nil || k == nil {
+		return data, err
+	}
+
+	// Random data key:
+	key := new([32]byte)
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, err
+	}
+
+	// Wrap the data key:
+	env := &sealed{Sealed: 1, KEK: k.name()}
+	if err := k.wrap(env, key); err != nil {
+		return nil, err
+	}
+
+	// Encrypt the state:
+	if env.Data, err = seal(data, key); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(env, "", "  ")
+}
+
+//-----------------------------------------------------------------------------
+// func: Unseal
+//-----------------------------------------------------------------------------
+
+// Unseal decrypts sealed data, plain data is returned as is.
+func Unseal(data []byte) ([]byte, error) {
+
+	// Plain state files have no envelope:
+	env := &sealed{}
+	if err := json.Unmarshal(data, env); err != nil || env.Sealed == 0 {
+		return data, nil
+	}
+
+	k, err := newKEK()
+	if err != nil {
+		return nil, err
+	}
+
+	if k == nil || k.name() != env.KEK {
+		return nil, errors.New("The state is sealed with a " + env.KEK +
+			" key, see KATO_STATE_PASSPHRASE, KATO_STATE_KEY_FILE and KATO_STATE_KMS_KEY_ID")
+	}
+
+	// Unwrap the data key and decrypt the state:
+	key, err := k.unwrap(env)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(env.Data, key)
+}
+
+//-----------------------------------------------------------------------------
+// KEK implementations:
+//-----------------------------------------------------------------------------
+
+type passphraseKEK struct {
+	passphrase string
+}
+
+func (k *passphraseKEK) name() string { return "passphrase" }
+
+func (k *passphraseKEK) derive(salt []byte) (*[32]byte, error) {
+	raw, err := scrypt.Key([]byte(k.passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	key := new([32]byte)
+	copy(key[:], raw)
+	return key, nil
+}
+
+func (k *passphraseKEK) wrap(env *sealed, key *[32]byte) error {
+
+	env.Salt = make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, env.Salt); err != nil {
+		return err
+	}
+
+	kek, err := k.derive(env.Salt)
+	if err != nil {
+		return err
+	}
+
+	env.Key, err = seal(key[:], kek)
+	return err
+}
+
+func (k *passphraseKEK) unwrap(env *sealed) (*[32]byte, error) {
+
+	kek, err := k.derive(env.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return openKey(env.Key, kek)
+}
+
+type keyfileKEK struct {
+	key [32]byte
+}
+
+func (k *keyfileKEK) name() string { return "keyfile" }
+
+func (k *keyfileKEK) wrap(env *sealed, key *[32]byte) (err error) {
+	env.Key, err = seal(key[:], &k.key)
+	return
+}
+
+func (k *keyfileKEK) unwrap(env *sealed) (*[32]byte, error) {
+	return openKey(env.Key, &k.key)
+}
+
+type kmsKEK struct {
+	kms   *kms.KMS
+	keyID string
+}
+
+func (k *kmsKEK) name() string { return "kms" }
+
+func (k *kmsKEK) wrap(env *sealed, key *[32]byte) error {
+
+	resp, err := k.kms.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(k.keyID),
+		Plaintext: key[:],
+	})
+	if err != nil {
+		return err
+	}
+
+	env.Key = resp.CiphertextBlob
+	return nil
+}
+
+func (k *kmsKEK) unwrap(env *sealed) (*[32]byte, error) {
+
+	resp, err := k.kms.Decrypt(&kms.DecryptInput{CiphertextBlob: env.Key})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Plaintext) != 32 {
+		return nil, errors.New("Invalid data key")
+	}
+
+	key := new([32]byte)
+	copy(key[:], resp.Plaintext)
+	return key, nil
+}
+
+//-----------------------------------------------------------------------------
+// NaCl secretbox helpers:
+//-----------------------------------------------------------------------------
+
+// seal encrypts msg with key, the nonce is prepended.
+func seal(msg []byte, key *[32]byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], msg, &nonce, key), nil
+}
+
+// open decrypts what seal encrypted.
+func open(box []byte, key *[32]byte) ([]byte, error) {
+	if len(box) < 24 {
+		return nil, errors.New("Invalid sealed data")
+	}
+	var nonce [24]byte
+	copy(nonce[:], box[:24])
+	msg, ok := secretbox.Open(nil, box[24:], &nonce, key)
+	if !ok {
+		return nil, errors.New("Unable to decrypt the state, wrong key?")
+	}
+	return msg, nil
+}
+
+// openKey decrypts a wrapped data key.
+func openKey(box []byte, kek *[32]byte) (*[32]byte, error) {
+	raw, err := open(box, kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, errors.New("Invalid data key")
+	}
+	key := new([32]byte)
+	copy(key[:], raw)
+	return key, nil
+}
@@ -0,0 +1,205 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// StateBackend stores the cluster state files. A missing state file must be
+// reported with an error satisfying os.IsNotExist().
+type StateBackend interface {
+	Read(clusterID string) ([]byte, error)
+	Write(clusterID string, data []byte) error
+	Archive(clusterID string) (string, error)
+	Lock(clusterID, holder string) error
+	Unlock(clusterID, holder string) error
+	ForceUnlock(clusterID string) (string, error)
+}
+
+// SecretFields are the state fields holding secrets.
+var SecretFields = []string{"DNSApiKey", "SMTPURL", "SlackWebhook"}
+
+//-----------------------------------------------------------------------------
+// func: NewStateBackend
+//-----------------------------------------------------------------------------
+
+// NewStateBackend returns the backend described by $KATO_STATE_BACKEND:
+//
+//	file:///path/to/dir (default: ~/.kato)
+//	s3://bucket/prefix?endpoint=http://127.0.0.1:9000&region=us-east-1
+//	etcd://127.0.0.1:2379/prefix
+func NewStateBackend() (StateBackend, error) {
+
+	raw := os.Getenv("KATO_STATE_BACKEND")
+	if raw == "" {
+		return &fileBackend{dir: os.Getenv("HOME") + "/.kato"}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &fileBackend{dir: u.Path}, nil
+	case "s3":
+		return newS3Backend(u)
+	case "etcd":
+		return &etcdBackend{endpoint: "http://" + u.Host, prefix: u.Path}, nil
+	}
+
+	return nil, errors.New("Unsupported state backend: " + u.Scheme)
+}
+
+//-----------------------------------------------------------------------------
+// func: DumpState
+//-----------------------------------------------------------------------------
+
+// DumpState serializes the given state as a clusterID JSON file.
+func DumpState(s interface{}, clusterID string) error {
+
+	// Marshal the data:
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteState(clusterID, data)
+}
+
+//-----------------------------------------------------------------------------
+// func: WriteState
+//-----------------------------------------------------------------------------
+
+// WriteState writes the raw ClusterID state file, sealed if a state key is
+// configured.
+func WriteState(clusterID string, data []byte) error {
+
+	// Encrypt the data:
+	data, err := Seal(data)
+	if err != nil {
+		return err
+	}
+
+	// Write the state file:
+	b, err := NewStateBackend()
+	if err != nil {
+		return err
+	}
+
+	return b.Write(clusterID, data)
+}
+
+//-----------------------------------------------------------------------------
+// func: ReadState
+//-----------------------------------------------------------------------------
+
+// ReadState reads the current ClusterID state file, unsealed if needed.
+func ReadState(clusterID string) ([]byte, error) {
+
+	b, err := NewStateBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := b.Read(clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Unseal(data)
+}
+
+//-----------------------------------------------------------------------------
+// func: ArchiveState
+//-----------------------------------------------------------------------------
+
+// ArchiveState moves the current ClusterID state file out of the way and
+// returns its new location.
+func ArchiveState(clusterID string) (string, error) {
+
+	b, err := NewStateBackend()
+	if err != nil {
+		return "", err
+	}
+
+	return b.Archive(clusterID)
+}
+
+//-----------------------------------------------------------------------------
+// func: LockState
+//-----------------------------------------------------------------------------
+
+// LockState takes the exclusive lock of the ClusterID state and returns the
+// function that releases it.
+func LockState(clusterID string) (func() error, error) {
+
+	b, err := NewStateBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	// Take the lock (the timestamp tells stale locks apart):
+	host, _ := os.Hostname()
+	holder := os.Getenv("USER") + "@" + host + ":" + strconv.Itoa(os.Getpid()) +
+		" since " + time.Now().UTC().Format(time.RFC3339)
+	if err := b.Lock(clusterID, holder); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return b.Unlock(clusterID, holder)
+	}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ForceUnlockState
+//-----------------------------------------------------------------------------
+
+// ForceUnlockState releases the lock of the ClusterID state whoever holds it
+// and returns the former holder. Meant for locks left behind by dead runs.
+func ForceUnlockState(clusterID string) (string, error) {
+
+	b, err := NewStateBackend()
+	if err != nil {
+		return "", err
+	}
+
+	return b.ForceUnlock(clusterID)
+}
+
+//-----------------------------------------------------------------------------
+// Helpers:
+//-----------------------------------------------------------------------------
+
+// notExist mimics the error of a missing local state file.
+func notExist(path string) error {
+	return &os.PathError{Op: "open", Path: path, Err: syscall.ENOENT}
+}
+
+// lockedBy is returned when somebody else holds the lock.
+func lockedBy(clusterID, holder string) error {
+	return errors.New("State of " + clusterID + " is locked by " + holder +
+		", run 'katoctl state unlock --force' if the holder is gone")
+}
+
+// archiveName is the name of an archived state file.
+func archiveName(clusterID string) string {
+	return clusterID + "-" + time.Now().UTC().Format("20060102T150405Z") + ".json"
+}
@@ -0,0 +1,160 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// State keys in an etcd v2 keyspace:
+type etcdBackend struct {
+	endpoint string
+	prefix   string
+}
+
+//-----------------------------------------------------------------------------
+// func: do
+//-----------------------------------------------------------------------------
+
+// do sends a keys API request and returns the node value.
+func (b *etcdBackend) do(method, key string, form url.Values) (string, int, error) {
+
+	// Forge the request:
+	u := b.endpoint + "/v2/keys" + strings.TrimSuffix(b.prefix, "/") + "/" + key
+	var req *http.Request
+	var err error
+
+	if method == "GET" || method == "DELETE" {
+		req, err = http.NewRequest(method, u+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, u, strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Send the request:
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	raw, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", res.StatusCode, err
+	}
+
+	// Decode the response:
+	resp := struct {
+		Message string `json:"message"`
+		Node    struct {
+			Value string `json:"value"`
+		} `json:"node"`
+	}{}
+
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return "", res.StatusCode, err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return "", res.StatusCode, errors.New(method + " " + u + ": " + resp.Message)
+	}
+
+	return resp.Node.Value, res.StatusCode, nil
+}
+
+//-----------------------------------------------------------------------------
+// StateBackend implementation:
+//-----------------------------------------------------------------------------
+
+func (b *etcdBackend) Read(clusterID string) ([]byte, error) {
+
+	value, status, err := b.do("GET", clusterID+".json", url.Values{})
+	if status == http.StatusNotFound {
+		return nil, notExist(b.endpoint + b.prefix + "/" + clusterID + ".json")
+	}
+
+	return []byte(value), err
+}
+
+func (b *etcdBackend) Write(clusterID string, data []byte) error {
+	_, _, err := b.do("PUT", clusterID+".json", url.Values{"value": {string(data)}})
+	return err
+}
+
+func (b *etcdBackend) Archive(clusterID string) (string, error) {
+
+	data, err := b.Read(clusterID)
+	if err != nil {
+		return "", err
+	}
+
+	archive := "archive/" + archiveName(clusterID)
+	if _, _, err := b.do("PUT", archive, url.Values{"value": {string(data)}}); err != nil {
+		return "", err
+	}
+
+	if _, _, err := b.do("DELETE", clusterID+".json", url.Values{}); err != nil {
+		return "", err
+	}
+
+	return b.endpoint + b.prefix + "/" + archive, nil
+}
+
+// Lock relies on the atomic create of the keys API.
+func (b *etcdBackend) Lock(clusterID, holder string) error {
+
+	_, status, err := b.do("PUT", clusterID+".lock", url.Values{
+		"value":     {holder},
+		"prevExist": {"false"},
+	})
+
+	if status == http.StatusPreconditionFailed {
+		owner, _, _ := b.do("GET", clusterID+".lock", url.Values{})
+		return lockedBy(clusterID, owner)
+	}
+
+	return err
+}
+
+// Unlock only deletes the lock if it's still ours.
+func (b *etcdBackend) Unlock(clusterID, holder string) error {
+	_, _, err := b.do("DELETE", clusterID+".lock", url.Values{"prevValue": {holder}})
+	return err
+}
+
+// ForceUnlock deletes the lock whoever holds it.
+func (b *etcdBackend) ForceUnlock(clusterID string) (string, error) {
+
+	owner, status, err := b.do("GET", clusterID+".lock", url.Values{})
+	if status == http.StatusNotFound {
+		return "", notExist(b.endpoint + b.prefix + "/" + clusterID + ".lock")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	_, _, err = b.do("DELETE", clusterID+".lock", url.Values{})
+	return owner, err
+}
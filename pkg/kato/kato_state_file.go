@@ -0,0 +1,130 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Local state files in a directory:
+type fileBackend struct {
+	dir string
+}
+
+//-----------------------------------------------------------------------------
+// func: Read
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) Read(clusterID string) ([]byte, error) {
+	return ioutil.ReadFile(b.dir + "/" + clusterID + ".json")
+}
+
+//-----------------------------------------------------------------------------
+// func: Write
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) Write(clusterID string, data []byte) error {
+
+	// Create the state directory:
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return err
+	}
+
+	// Write the state file:
+	return ioutil.WriteFile(b.dir+"/"+clusterID+".json", data, 0600)
+}
+
+//-----------------------------------------------------------------------------
+// func: Archive
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) Archive(clusterID string) (string, error) {
+
+	// Create the archive directory:
+	if err := os.MkdirAll(b.dir+"/archive", 0700); err != nil {
+		return "", err
+	}
+
+	// Move the state file:
+	archive := b.dir + "/archive/" + archiveName(clusterID)
+	if err := os.Rename(b.dir+"/"+clusterID+".json", archive); err != nil {
+		return "", err
+	}
+
+	return archive, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: Lock
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) Lock(clusterID, holder string) error {
+
+	// Create the state directory:
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return err
+	}
+
+	// Exclusive creation of the lock file:
+	path := b.dir + "/" + clusterID + ".lock"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			owner, _ := ioutil.ReadFile(path)
+			return lockedBy(clusterID, strings.TrimSpace(string(owner))+" ("+path+")")
+		}
+		return err
+	}
+
+	if _, err := f.WriteString(holder + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+//-----------------------------------------------------------------------------
+// func: Unlock
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) Unlock(clusterID, holder string) error {
+
+	// Only the holder can release the lock:
+	path := b.dir + "/" + clusterID + ".lock"
+	owner, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(owner)) != holder {
+		return lockedBy(clusterID, strings.TrimSpace(string(owner)))
+	}
+
+	return os.Remove(path)
+}
+
+//-----------------------------------------------------------------------------
+// func: ForceUnlock
+//-----------------------------------------------------------------------------
+
+func (b *fileBackend) ForceUnlock(clusterID string) (string, error) {
+
+	path := b.dir + "/" + clusterID + ".lock"
+	owner, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(owner)), os.Remove(path)
+}
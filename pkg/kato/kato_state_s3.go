@@ -0,0 +1,194 @@
+package kato
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	// Community:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// State objects in an S3 compatible bucket:
+type s3Backend struct {
+	s3     *s3.S3
+	bucket string
+	prefix string
+}
+
+//-----------------------------------------------------------------------------
+// func: newS3Backend
+//-----------------------------------------------------------------------------
+
+func newS3Backend(u *url.URL) (*s3Backend, error) {
+
+	// Default to AWS, any S3 compatible endpoint works:
+	cfg := &aws.Config{Region: aws.String("us-east-1")}
+	if region := u.Query().Get("region"); region != "" {
+		cfg.Region = aws.String(region)
+	}
+
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &s3Backend{s3: s3.New(sess), bucket: u.Host, prefix: prefix}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: get
+//-----------------------------------------------------------------------------
+
+func (b *s3Backend) get(key string) ([]byte, error) {
+
+	resp, err := b.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + key),
+	})
+
+	if err != nil {
+		if s3err, ok := err.(awserr.Error); ok && s3err.Code() == s3.ErrCodeNoSuchKey {
+			return nil, notExist("s3://" + b.bucket + "/" + b.prefix + key)
+		}
+		return nil, err
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+	return ioutil.ReadAll(resp.Body)
+}
+
+//-----------------------------------------------------------------------------
+// func: put
+//-----------------------------------------------------------------------------
+
+func (b *s3Backend) put(key string, data []byte) error {
+	_, err := b.s3.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// func: del
+//-----------------------------------------------------------------------------
+
+func (b *s3Backend) del(key string) error {
+	_, err := b.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + key),
+	})
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// StateBackend implementation:
+//-----------------------------------------------------------------------------
+
+func (b *s3Backend) Read(clusterID string) ([]byte, error) {
+	return b.get(clusterID + ".json")
+}
+
+func (b *s3Backend) Write(clusterID string, data []byte) error {
+	return b.put(clusterID+".json", data)
+}
+
+func (b *s3Backend) Archive(clusterID string) (string, error) {
+
+	data, err := b.get(clusterID + ".json")
+	if err != nil {
+		return "", err
+	}
+
+	archive := "archive/" + archiveName(clusterID)
+	if err := b.put(archive, data); err != nil {
+		return "", err
+	}
+
+	if err := b.del(clusterID + ".json"); err != nil {
+		return "", err
+	}
+
+	return "s3://" + b.bucket + "/" + b.prefix + archive, nil
+}
+
+// Lock creates the lock object with a conditional write: S3 (and MinIO)
+// reject an 'If-None-Match: *' PUT with 412 when the key already exists.
+func (b *s3Backend) Lock(clusterID, holder string) error {
+
+	key := clusterID + ".lock"
+	req, _ := b.s3.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.prefix + key),
+		Body:   bytes.NewReader([]byte(holder)),
+	})
+
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+	err := req.Send()
+	if err == nil {
+		return nil
+	}
+
+	// 409 is returned to the loser of two concurrent conditional writes:
+	if reqErr, ok := err.(awserr.RequestFailure); ok &&
+		(reqErr.StatusCode() == http.StatusPreconditionFailed ||
+			reqErr.StatusCode() == http.StatusConflict) {
+		owner, _ := b.get(key)
+		return lockedBy(clusterID, string(owner))
+	}
+
+	return err
+}
+
+func (b *s3Backend) Unlock(clusterID, holder string) error {
+
+	key := clusterID + ".lock"
+	owner, err := b.get(key)
+	if err != nil {
+		return err
+	}
+
+	if string(owner) != holder {
+		return lockedBy(clusterID, string(owner))
+	}
+
+	return b.del(key)
+}
+
+func (b *s3Backend) ForceUnlock(clusterID string) (string, error) {
+
+	key := clusterID + ".lock"
+	owner, err := b.get(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(owner), b.del(key)
+}
@@ -1 +1,307 @@
 package kato
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// S3 stand-in for path style requests, it honours 'If-None-Match: *' like
+// S3 and MinIO do:
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	data, ok := f.objects[r.URL.Path]
+
+	switch r.Method {
+
+	case "GET":
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>"))
+			return
+		}
+		_, _ = w.Write(data)
+
+	case "PUT":
+		if ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = w.Write([]byte("<Error><Code>PreconditionFailed</Code><Message>Exists</Message></Error>"))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = body
+
+	case "DELETE":
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// etcd v2 keys API stand-in, it honours 'prevExist' and 'prevValue' like
+// etcd does:
+type fakeEtcd struct {
+	sync.Mutex
+	keys map[string]string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	_ = r.ParseForm()
+	key := strings.TrimPrefix(r.URL.Path, "/v2/keys")
+	value, ok := f.keys[key]
+
+	reply := func(status, code int, message string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"errorCode": code, "message": message,
+			"node": map[string]string{"key": key, "value": value}})
+	}
+
+	switch {
+
+	case !ok && r.Method != "PUT":
+		reply(http.StatusNotFound, 100, "Key not found")
+
+	case ok && r.Method == "PUT" && r.Form.Get("prevExist") == "false":
+		reply(http.StatusPreconditionFailed, 105, "Key already exists")
+
+	case r.Method == "DELETE" && r.Form.Get("prevValue") != "" && r.Form.Get("prevValue") != value:
+		reply(http.StatusPreconditionFailed, 101, "Compare failed")
+
+	case r.Method == "PUT":
+		value = r.Form.Get("value")
+		f.keys[key] = value
+		reply(http.StatusCreated, 0, "")
+
+	case r.Method == "DELETE":
+		delete(f.keys, key)
+		reply(http.StatusOK, 0, "")
+
+	default:
+		reply(http.StatusOK, 0, "")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: setKEK
+//-----------------------------------------------------------------------------
+
+// setKEK configures the state key the way katoctl reads it: kind is one of
+// passphrase, keyfile or the empty string for none.
+func setKEK(t *testing.T, kind, secret string) {
+
+	os.Unsetenv("KATO_STATE_PASSPHRASE")
+	os.Unsetenv("KATO_STATE_KEY_FILE")
+	os.Unsetenv("KATO_STATE_KMS_KEY_ID")
+
+	switch kind {
+
+	case "passphrase":
+		os.Setenv("KATO_STATE_PASSPHRASE", secret)
+
+	case "keyfile":
+		f, err := ioutil.TempFile("", "kato-key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		key := bytes.Repeat([]byte(secret), 32)[:32]
+		if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("KATO_STATE_KEY_FILE", f.Name())
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestSealUnseal
+//-----------------------------------------------------------------------------
+
+func TestSealUnseal(t *testing.T) {
+
+	defer setKEK(t, "", "")
+	state := []byte(`{"ClusterID": "c1", "CaCert": "-----BEGIN CERTIFICATE-----"}`)
+
+	tests := []struct {
+		name       string
+		seal       string // KEK kind used to seal
+		sealWith   string // and its secret
+		unseal     string // KEK kind used to unseal
+		unsealWith string // and its secret
+		fail       bool
+	}{
+		{name: "plain", seal: "", unseal: ""},
+		{name: "plain read with a key", seal: "", unseal: "passphrase", unsealWith: "s3cr3t"},
+		{name: "passphrase", seal: "passphrase", sealWith: "s3cr3t", unseal: "passphrase", unsealWith: "s3cr3t"},
+		{name: "keyfile", seal: "keyfile", sealWith: "a", unseal: "keyfile", unsealWith: "a"},
+		{name: "wrong passphrase", seal: "passphrase", sealWith: "s3cr3t", unseal: "passphrase", unsealWith: "guess", fail: true},
+		{name: "wrong keyfile", seal: "keyfile", sealWith: "a", unseal: "keyfile", unsealWith: "b", fail: true},
+		{name: "wrong kind of key", seal: "passphrase", sealWith: "s3cr3t", unseal: "keyfile", unsealWith: "a", fail: true},
+		{name: "no key", seal: "keyfile", sealWith: "a", unseal: "", fail: true},
+	}
+
+	for _, tt := range tests {
+
+		setKEK(t, tt.seal, tt.sealWith)
+		if f := os.Getenv("KATO_STATE_KEY_FILE"); f != "" {
+			defer os.Remove(f)
+		}
+
+		box, err := Seal(state)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if tt.seal != "" && bytes.Contains(box, []byte("ClusterID")) {
+			t.Errorf("%s: the sealed state is in the clear", tt.name)
+		}
+
+		setKEK(t, tt.unseal, tt.unsealWith)
+		if f := os.Getenv("KATO_STATE_KEY_FILE"); f != "" {
+			defer os.Remove(f)
+		}
+
+		plain, err := Unseal(box)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+
+		if !bytes.Equal(plain, state) {
+			t.Errorf("%s: got %s", tt.name, plain)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestUnsealTampered
+//-----------------------------------------------------------------------------
+
+func TestUnsealTampered(t *testing.T) {
+
+	defer setKEK(t, "", "")
+	setKEK(t, "keyfile", "a")
+	defer os.Remove(os.Getenv("KATO_STATE_KEY_FILE"))
+
+	box, err := Seal([]byte(`{"ClusterID": "c1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &sealed{}
+	if err := json.Unmarshal(box, env); err != nil {
+		t.Fatal(err)
+	}
+
+	env.Data[len(env.Data)-1] ^= 1
+	tampered, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Unseal(tampered); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestLockState
+//-----------------------------------------------------------------------------
+
+func TestLockState(t *testing.T) {
+
+	defer os.Unsetenv("KATO_STATE_BACKEND")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	os.Setenv("AWS_ACCESS_KEY_ID", "kato")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	dir, err := ioutil.TempDir("", "kato-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	etcd := httptest.NewServer(&fakeEtcd{keys: map[string]string{}})
+	defer etcd.Close()
+
+	for _, backend := range []string{
+		"file://" + dir,
+		"s3://bucket/prefix?endpoint=" + srv.URL,
+		"etcd://" + strings.TrimPrefix(etcd.URL, "http://") + "/kato",
+	} {
+
+		os.Setenv("KATO_STATE_BACKEND", backend)
+
+		unlock, err := LockState("c1")
+		if err != nil {
+			t.Fatalf("%s: %s", backend, err)
+		}
+
+		// A second run is turned away:
+		if _, err := LockState("c1"); err == nil || !strings.Contains(err.Error(), "locked by") {
+			t.Errorf("%s: expected a locked error, got %v", backend, err)
+		}
+
+		// Other clusters are not:
+		unlock2, err := LockState("c2")
+		if err != nil {
+			t.Errorf("%s: %s", backend, err)
+		} else if err := unlock2(); err != nil {
+			t.Errorf("%s: %s", backend, err)
+		}
+
+		// Release and take it again:
+		if err := unlock(); err != nil {
+			t.Errorf("%s: %s", backend, err)
+		}
+
+		if _, err := LockState("c1"); err != nil {
+			t.Errorf("%s: %s", backend, err)
+		}
+
+		// The holder of a stale lock is reported:
+		holder, err := ForceUnlockState("c1")
+		if err != nil || !strings.Contains(holder, " since ") {
+			t.Errorf("%s: got holder %q, %v", backend, holder, err)
+		}
+
+		if _, err := ForceUnlockState("c1"); err == nil {
+			t.Errorf("%s: expected an error without a lock", backend)
+		}
+	}
+}
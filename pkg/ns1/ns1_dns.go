@@ -0,0 +1,114 @@
+package ns1
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// func: init
+//-----------------------------------------------------------------------------
+
+func init() {
+	dns.Register("ns1", func(apiKey string) dns.Provider {
+		return &Data{APIKey: apiKey}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: ShowRecords
+//-----------------------------------------------------------------------------
+
+// ShowRecords prints the records of an NS1 zone matching the name and type
+// filters.
+func (d *Data) ShowRecords() {
+	if err := dns.Show(d, d.Zone, d.Name, d.Type, d.Output); err != nil {
+		log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": d.Zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: ListZones
+//-----------------------------------------------------------------------------
+
+// ListZones returns the names of all the NS1 zones.
+func (d *Data) ListZones() ([]string, error) {
+
+	// Set the current command:
+	d.command = "zone:list"
+	d.client()
+
+	// Send the zone list request:
+	list, _, err := d.ns1.Zones.List()
+	if err != nil {
+		return nil, err
+	}
+
+	zones := []string{}
+	for _, z := range list {
+		zones = append(zones, z.Zone)
+	}
+
+	return zones, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ListRecords
+//-----------------------------------------------------------------------------
+
+// ListRecords returns all the records of an NS1 zone.
+func (d *Data) ListRecords(zone string) ([]dns.Record, error) {
+
+	// Set the current command:
+	d.command = "record:list"
+	d.client()
+
+	// Send the zone request:
+	zone = strings.TrimSuffix(zone, ".")
+	z, _, err := d.ns1.Zones.Get(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []dns.Record{}
+	for _, r := range z.Records {
+		records = append(records, dns.Record{
+			Name: dns.RelativeName(r.Domain, zone),
+			Type: r.Type,
+			TTL:  r.TTL,
+			Data: r.ShortAns,
+		})
+	}
+
+	return records, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: NameServers
+//-----------------------------------------------------------------------------
+
+// NameServers returns the NS1 name servers of zone.
+func (d *Data) NameServers(zone string) ([]string, error) {
+
+	d.client()
+
+	// Send the zone request:
+	z, _, err := d.ns1.Zones.Get(strings.TrimSuffix(zone, "."))
+	if err != nil {
+		return nil, err
+	}
+
+	return z.DNSServers, nil
+}
@@ -24,18 +24,49 @@ var (
 	cmdNs1ZoneAdd    = cmdNs1Zone.Command("add", "Adds NS1 zones.")
 	arNs1ZoneAddName = cmdNs1ZoneAdd.Arg("fqdn",
 		"List of zones to publish.").Required().Strings()
+	flNs1ZoneAddParent = cmdNs1ZoneAdd.Flag("parent-provider",
+		"Delegate from a parent zone of this provider [ ns1 | r53 | rfc2136 ]").String()
+	flNs1ZoneAddParentKey = cmdNs1ZoneAdd.Flag("parent-api-key",
+		"API key of the parent provider.").String()
 
 	// ns1 zone del:
 	cmdNs1ZoneDel    = cmdNs1Zone.Command("del", "Deletes NS1 zones.")
 	arNs1ZoneDelName = cmdNs1ZoneDel.Arg("fqdn",
 		"List of zones to delete.").Required().Strings()
+	flNs1ZoneDelRecursive = cmdNs1ZoneDel.Flag("recursive",
+		"Purge records, child zones and the parent delegation first.").Bool()
+	flNs1ZoneDelParent = cmdNs1ZoneDel.Flag("parent-provider",
+		"Remove the delegation from this provider [ ns1 | r53 | rfc2136 ]").String()
+	flNs1ZoneDelParentKey = cmdNs1ZoneDel.Flag("parent-api-key",
+		"API key of the parent provider.").String()
 
 	// ns1 record add:
 	cmdNs1RecordAdd    = cmdNs1Record.Command("add", "Adds records to NS1 zones.")
 	flNs1RecordAddZone = cmdNs1RecordAdd.Flag("zone",
 		"DNS zone where records are added.").Required().String()
 	arNs1RecordAddName = cmdNs1RecordAdd.Arg("record",
-		"List of name:type:data records.").Required().Strings()
+		"List of 'name [ttl] type data', JSON or name:type:data records.").
+		Required().Strings()
+
+	// ns1 record del:
+	cmdNs1RecordDel    = cmdNs1Record.Command("del", "Deletes records from NS1 zones.")
+	flNs1RecordDelZone = cmdNs1RecordDel.Flag("zone",
+		"DNS zone where records are deleted.").Required().String()
+	arNs1RecordDelName = cmdNs1RecordDel.Arg("record",
+		"List of name:type records.").Required().Strings()
+
+	// ns1 record list:
+	cmdNs1RecordList = cmdNs1Record.Command("list",
+		"Lists records of NS1 zones, exits 2 if none matches.")
+	flNs1RecordListZone = cmdNs1RecordList.Flag("zone",
+		"DNS zone where records are listed.").Required().String()
+	flNs1RecordListName = cmdNs1RecordList.Flag("name",
+		"Only records whose name matches this glob.").String()
+	flNs1RecordListType = cmdNs1RecordList.Flag("type",
+		"Only records of this type.").String()
+	flNs1RecordListOutput = cmdNs1RecordList.Flag("output",
+		"Output format [ text | json ]").
+		Default("text").Enum("text", "json")
 )
 
 //-----------------------------------------------------------------------------
@@ -52,6 +83,9 @@ func RunCmd(cmd string) bool {
 		d := Data{
 			APIKey: *flNs1APIKey,
 			Zones:  *arNs1ZoneAddName,
+
+			ParentProvider: *flNs1ZoneAddParent,
+			ParentAPIKey:   *flNs1ZoneAddParentKey,
 		}
 		d.AddZones()
 
@@ -60,6 +94,10 @@ func RunCmd(cmd string) bool {
 		d := Data{
 			APIKey: *flNs1APIKey,
 			Zones:  *arNs1ZoneDelName,
+
+			Recursive:      *flNs1ZoneDelRecursive,
+			ParentProvider: *flNs1ZoneDelParent,
+			ParentAPIKey:   *flNs1ZoneDelParentKey,
 		}
 		d.DelZones()
 
@@ -72,6 +110,26 @@ func RunCmd(cmd string) bool {
 		}
 		d.AddRecords()
 
+	// katoctl ns1 record del:
+	case cmdNs1RecordDel.FullCommand():
+		d := Data{
+			APIKey:  *flNs1APIKey,
+			Zone:    *flNs1RecordDelZone,
+			Records: *arNs1RecordDelName,
+		}
+		d.DelRecords()
+
+	// katoctl ns1 record list:
+	case cmdNs1RecordList.FullCommand():
+		d := Data{
+			APIKey: *flNs1APIKey,
+			Zone:   *flNs1RecordListZone,
+			Name:   *flNs1RecordListName,
+			Type:   *flNs1RecordListType,
+			Output: *flNs1RecordListOutput,
+		}
+		d.ShowRecords()
+
 	// Nothing to do:
 	default:
 		return false
@@ -7,6 +7,7 @@ package ns1
 import (
 
 	// Stdlib:
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -14,7 +15,10 @@ import (
 	// Community:
 	log "github.com/Sirupsen/logrus"
 	api "gopkg.in/ns1/ns1-go.v2/rest"
-	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	model "gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
 )
 
 //-----------------------------------------------------------------------------
@@ -29,6 +33,13 @@ type Data struct {
 	APIKey  string
 	Zone    string
 	Records []string
+	Name    string
+	Type    string
+	Output  string
+
+	ParentProvider string // Delegate from a parent zone of this provider
+	ParentAPIKey   string // API key of the parent provider
+	Recursive      bool   // Purge records and child zones on deletion
 }
 
 //-----------------------------------------------------------------------------
@@ -37,17 +48,20 @@ type Data struct {
 
 // AddRecords adds one or more records to an NS1 zone.
 func (d *Data) AddRecords() {
+	if err := d.CreateRecords(d.Zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": d.Zone}).
+			Fatal(err)
+	}
+}
 
-	// Set the current command:
-	d.command = "record:add"
-
-	// Create an NS1 API client:
-	httpClient := &http.Client{Timeout: time.Second * 10}
-	d.ns1 = api.NewClient(httpClient, api.SetAPIKey(d.APIKey))
+//-----------------------------------------------------------------------------
+// func: DelRecords
+//-----------------------------------------------------------------------------
 
-	// For each requested record:
+// DelRecords deletes one or more records from an NS1 zone.
+func (d *Data) DelRecords() {
 	for _, record := range d.Records {
-		if err := d.addRecord(record); err != nil {
+		if err := d.DeleteRecords(d.Zone, record); err != nil {
 			log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": record}).
 				Fatal(err)
 		}
@@ -58,76 +72,242 @@ func (d *Data) AddRecords() {
 // func: AddZones
 //-----------------------------------------------------------------------------
 
-// AddZones adds one or more zones to NS1.
+// AddZones adds one or more zones to NS1. The zones without a parent in NS1
+// are delegated from the parent provider, if any.
 func (d *Data) AddZones() {
+	for _, zone := range d.Zones {
+		if err := d.CreateZones(zone); err != nil {
+			log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": zone}).
+				Fatal(err)
+		}
+	}
+
+	if err := dns.DelegateAcross(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+		log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": d.ParentProvider}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelZones
+//-----------------------------------------------------------------------------
+
+// DelZones deletes one or more zones from NS1. Recursive deletions purge
+// the records, child zones and parent delegations first.
+func (d *Data) DelZones() {
+
+	if d.Recursive {
+		d.command = "zone:del"
+		if err := dns.Purge(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+			log.WithField("cmd", "ns1:"+d.command).Fatal(err)
+		}
+		return
+	}
+
+	for _, zone := range d.Zones {
+		if err := d.DeleteZones(zone); err != nil {
+			log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": zone}).
+				Fatal(err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: CreateRecords
+//-----------------------------------------------------------------------------
+
+// CreateRecords creates or updates records in an NS1 zone. See
+// dns.ParseRecords for the accepted record specs.
+func (d *Data) CreateRecords(zone string, records ...string) error {
 
 	// Set the current command:
-	d.command = "zone:add"
+	d.command = "record:add"
+	d.Zone = strings.TrimSuffix(zone, ".")
+	d.client()
+
+	// Parse and validate all the records first:
+	sets, err := dns.ParseRecords(records...)
+	if err != nil {
+		return err
+	}
+
+	// For each requested record:
+	for _, r := range sets {
+		if err := d.addRecord(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: DeleteRecords
+//-----------------------------------------------------------------------------
 
-	// Create an NS1 API client:
-	httpClient := &http.Client{Timeout: time.Second * 10}
-	d.ns1 = api.NewClient(httpClient, api.SetAPIKey(d.APIKey))
+// DeleteRecords deletes name:type records from an NS1 zone.
+func (d *Data) DeleteRecords(zone string, records ...string) error {
+
+	// Set the current command:
+	d.command = "record:del"
+	d.Zone = zone
+	d.client()
+
+	// For each requested record:
+	for _, record := range records {
+		if err := d.delRecord(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: CreateZones
+//-----------------------------------------------------------------------------
+
+// CreateZones adds zones to NS1 and delegates them from their parent zone,
+// if any. Existing zones are left as they are.
+func (d *Data) CreateZones(zones ...string) error {
+
+	// Set the current command:
+	d.command = "zone:add"
+	d.client()
 
 	// For each requested zone:
-	for _, zone := range d.Zones {
+	for _, zone := range zones {
+
+		// Add the child zone:
+		zone = strings.TrimSuffix(zone, ".")
 		if err := d.addZone(zone); err != nil {
+			return err
+		}
+
+		// Delegate from the parent zone, if any:
+		p := &Data{ns1: d.ns1, command: d.command}
+		pZone, err := dns.Delegate(p, p, zone)
+		if err != nil {
+			return err
+		}
+
+		if pZone != "" {
 			log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": zone}).
-				Fatal(err)
+				Info("DNS zone delegated from " + pZone)
 		}
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: DelZones
+// func: DeleteZones
 //-----------------------------------------------------------------------------
 
-// DelZones deletes one or more zones from NS1.
-func (d *Data) DelZones() {
+// DeleteZones deletes zones from NS1.
+func (d *Data) DeleteZones(zones ...string) error {
 
 	// Set the current command:
 	d.command = "zone:del"
-
-	// Create an NS1 API client:
-	httpClient := &http.Client{Timeout: time.Second * 10}
-	d.ns1 = api.NewClient(httpClient, api.SetAPIKey(d.APIKey))
+	d.client()
 
 	// For each requested zone:
-	for _, zone := range d.Zones {
+	for _, zone := range zones {
 		if err := d.delZone(zone); err != nil {
-			log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": zone}).
-				Fatal(err)
+			return err
 		}
 	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: client
+//-----------------------------------------------------------------------------
+
+// client creates the NS1 API client once.
+func (d *Data) client() {
+	if d.ns1 == nil {
+		httpClient := &http.Client{Timeout: time.Second * 10}
+		d.ns1 = api.NewClient(httpClient, api.SetAPIKey(d.APIKey))
+	}
 }
 
 //-----------------------------------------------------------------------------
 // func: addRecord
 //-----------------------------------------------------------------------------
 
-func (d *Data) addRecord(record string) error {
+func (d *Data) addRecord(r dns.Record) error {
 
-	// Split into name:type:data
-	s := strings.Split(record, ":")
-	resourceName := s[0]
-	resourceType := s[1]
-	resourceData := s[2]
+	// The apex is '@':
+	name := r.Name + "." + d.Zone
+	if r.Name == "@" {
+		name = d.Zone
+	}
 
-	// Forge the record request:
-	rec := dns.NewRecord(d.Zone, resourceName, resourceType)
-	for _, data := range strings.Split(resourceData, ",") {
-		rec.AddAnswer(dns.NewAnswer([]string{data}))
+	// Forge the record request (TXT answers are a single string):
+	rec := model.NewRecord(d.Zone, name, r.Type)
+	if r.TTL > 0 {
+		rec.TTL = r.TTL
 	}
 
-	// Send the record request:
+	for _, data := range r.Data {
+		rdata := strings.Fields(data)
+		if r.Type == "TXT" {
+			rdata = []string{data}
+		}
+		rec.AddAnswer(model.NewAnswer(rdata))
+	}
+
+	// Send the record request, update if it exists:
 	if _, err := d.ns1.Records.Create(rec); err != nil {
 		if err != api.ErrRecordExists {
 			return err
 		}
+		if _, err := d.ns1.Records.Update(rec); err != nil {
+			return err
+		}
 	}
 
 	// Log record creation:
-	log.WithFields(log.Fields{"cmd": "ns1:" + d.command,
-		"id": resourceName + "." + d.Zone}).Info("New DNS record created/updated")
+	log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": name}).
+		Info("New DNS record created/updated")
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: delRecord
+//-----------------------------------------------------------------------------
+
+func (d *Data) delRecord(record string) error {
+
+	// Split into name:type
+	s := strings.Split(record, ":")
+	if len(s) != 2 {
+		return errors.New("Invalid record: " + record)
+	}
+
+	// The apex is '@' as in 'record list':
+	zone := strings.TrimSuffix(d.Zone, ".")
+	name := s[0] + "." + zone
+	if s[0] == "@" {
+		name = zone
+	}
+
+	// Send the delete record request:
+	if _, err := d.ns1.Records.Delete(zone, name, strings.ToUpper(s[1])); err != nil {
+		if err != api.ErrRecordMissing {
+			return err
+		}
+		log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": name}).
+			Info("Ops! this record does not exist")
+		return nil
+	}
+
+	// Log record deletion:
+	log.WithFields(log.Fields{"cmd": "ns1:" + d.command, "id": name}).
+		Info("DNS record deleted")
 
 	return nil
 }
@@ -139,7 +319,7 @@ func (d *Data) addRecord(record string) error {
 func (d *Data) addZone(zone string) error {
 
 	// Forge the zone request:
-	z := dns.NewZone(zone)
+	z := model.NewZone(zone)
 
 	// Send the zone request:
 	if _, err := d.ns1.Zones.Create(z); err != nil {
@@ -7,28 +7,56 @@ package pkt
 import (
 
 	// Stdlib:
+	"encoding/json"
 	"io/ioutil"
 	"os"
 
 	// Community:
 	log "github.com/Sirupsen/logrus"
+	"github.com/imdario/mergo"
 	"github.com/packethost/packngo"
+
+	// Local:
+	"github.com/katosys/kato/pkg/cluster"
+	"github.com/katosys/kato/pkg/kato"
 )
 
 //----------------------------------------------------------------------------
 // Typedefs:
 //----------------------------------------------------------------------------
 
+// State data.
+type State struct {
+	Quadruplets  []string `json:"Quadruplets"`  // deploy |       |
+	StubZones    []string `json:"StubZones"`    // deploy |       |
+	QuorumCount  int      `json:"QuorumCount"`  // deploy |       |
+	MasterCount  int      `json:"MasterCount"`  // deploy |       |
+	EtcdToken    string   `json:"EtcdToken"`    // deploy |       |
+	DNSProvider  string   `json:"DNSProvider"`  // deploy |       |
+	DNSApiKey    string   `json:"DNSApiKey"`    // deploy |       |
+	DNSZones     []string `json:"DNSZones"`     // deploy |       |
+	SlackWebhook string   `json:"SlackWebhook"` // deploy |       |
+	SMTPURL      string   `json:"SMTPURL"`      // deploy |       |
+	AdminEmail   string   `json:"AdminEmail"`   // deploy |       |
+	SecretsURL   string   `json:"SecretsURL"`   // deploy |       |
+	CaCertPath   string   `json:"CaCertPath"`   // deploy |       |
+	CalicoIPPool string   `json:"CalicoIPPool"` // deploy |       |
+	Domain       string   `json:"Domain"`       // deploy |       |
+	ClusterID    string   `json:"ClusterID"`    // deploy | setup |
+	ProjectID    string   `json:"ProjectID"`    // deploy | setup | run
+	Facility     string   `json:"Facility"`     // deploy | setup | run
+	OS           string   `json:"OS"`           // deploy |       | run
+	Billing      string   `json:"Billing"`      // deploy |       | run
+}
+
 // Data contains variables used by Packet.net API.
 type Data struct {
-	command   string
-	APIKey    string
-	HostName  string
-	ProjectID string
-	Plan      string
-	OS        string
-	Facility  string
-	Billing   string
+	command  string
+	APIKey   string
+	HostName string
+	Plan     string
+	client   *packngo.Client
+	State
 }
 
 //--------------------------------------------------------------------------
@@ -37,6 +65,25 @@ type Data struct {
 
 // Deploy Kato's infrastructure on Packet.net
 func (d *Data) Deploy() {
+
+	// Set current command:
+	d.command = "deploy"
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Connect and authenticate to the API endpoint:
+	d.client = packngo.NewClient("", d.APIKey, nil)
+
+	// Load state from state file (if any):
+	if err := d.loadState(); err != nil && !os.IsNotExist(err) {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
+
+	// Setup, save the state and deploy all the nodes:
+	if err := cluster.Deploy(d, d.clusterData()); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
 }
 
 //--------------------------------------------------------------------------
@@ -45,6 +92,30 @@ func (d *Data) Deploy() {
 
 // Setup a Packet.net project to be used by katoctl.
 func (d *Data) Setup() {
+
+	// Set current command:
+	d.command = "setup"
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Connect and authenticate to the API endpoint:
+	d.client = packngo.NewClient("", d.APIKey, nil)
+
+	// Load state from state file (if any):
+	if err := d.loadState(); err != nil && !os.IsNotExist(err) {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
+
+	// Setup the project:
+	if err := cluster.Setup(d); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
+
+	// Dump state to file:
+	if err := kato.DumpState(d.State, d.ClusterID); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
 }
 
 //--------------------------------------------------------------------------
@@ -86,3 +157,58 @@ func (d *Data) Run() {
 	// Pretty-print the response data:
 	log.WithField("cmd", "pkt:"+d.command).Info(newDevice)
 }
+
+//--------------------------------------------------------------------------
+// func: loadState
+//--------------------------------------------------------------------------
+
+func (d *Data) loadState() error {
+
+	// Read raw data from state file:
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		return err
+	}
+
+	// Decode the loaded JSON data:
+	dat := State{}
+	if err := json.Unmarshal(raw, &dat); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return err
+	}
+
+	// Merge the decoded data into the current state:
+	if err := mergo.Map(&d.State, dat); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return err
+	}
+
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: lockState
+//--------------------------------------------------------------------------
+
+// lockState takes the state lock or exits. The returned function releases
+// the lock, it also runs if the command exits with a fatal error.
+func (d *Data) lockState() func() {
+
+	unlock, err := kato.LockState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Fatal(err)
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			if err := unlock(); err != nil {
+				log.WithField("cmd", "pkt:"+d.command).Warning(err)
+			}
+		}
+	}
+
+	log.RegisterExitHandler(release)
+	return release
+}
@@ -0,0 +1,262 @@
+package pkt
+
+//---------------------------------------------------------------------------
+// Package factored import statement:
+//---------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/packethost/packngo"
+
+	// Local:
+	"github.com/katosys/kato/pkg/cluster"
+	"github.com/katosys/kato/pkg/kato"
+	"github.com/katosys/kato/pkg/udata"
+)
+
+//--------------------------------------------------------------------------
+// func: Name
+//--------------------------------------------------------------------------
+
+// Name returns the provider name.
+func (d *Data) Name() string {
+	return "pkt"
+}
+
+//--------------------------------------------------------------------------
+// func: SetupNetwork
+//--------------------------------------------------------------------------
+
+// SetupNetwork checks the project, Packet.net networks come with it.
+func (d *Data) SetupNetwork() error {
+
+	project, _, err := d.client.Projects.Get(d.ProjectID)
+	if err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "pkt:" + d.command, "id": d.ProjectID}).
+		Info("Using project " + project.Name)
+
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: SetupFirewall
+//--------------------------------------------------------------------------
+
+// SetupFirewall does nothing, Packet.net has no firewall API.
+func (d *Data) SetupFirewall() error {
+	log.WithField("cmd", "pkt:"+d.command).
+		Info("No firewall on Packet.net, skipping")
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: SetupBalancer
+//--------------------------------------------------------------------------
+
+// SetupBalancer does nothing, Packet.net has no load balancer API.
+func (d *Data) SetupBalancer() error {
+	log.WithField("cmd", "pkt:"+d.command).
+		Info("No load balancer on Packet.net, skipping")
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: Udata
+//--------------------------------------------------------------------------
+
+// Udata leaves the user data flags alone, the defaults fit Packet.net.
+func (d *Data) Udata(flags *udata.CmdFlags) {
+}
+
+//--------------------------------------------------------------------------
+// func: RunInstance
+//--------------------------------------------------------------------------
+
+// RunInstance launches the node with the given user data. The roles are
+// kept in the device tags.
+func (d *Data) RunInstance(n *cluster.Node, udata []byte) error {
+
+	// Forge the request:
+	createRequest := &packngo.DeviceCreateRequest{
+		Hostname:     n.FQDN(d.Domain),
+		Plan:         n.InstanceType,
+		Facility:     d.Facility,
+		OS:           d.OS,
+		BillingCycle: d.Billing,
+		ProjectID:    d.ProjectID,
+		UserData:     string(udata),
+		Tags:         n.Roles,
+	}
+
+	// Send the request:
+	device, _, err := d.client.Devices.Create(createRequest)
+	if err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return err
+	}
+
+	n.ID = device.ID
+	log.WithFields(log.Fields{"cmd": "pkt:" + d.command, "id": n.ID}).
+		Info("New " + n.InstanceType + " device requested")
+
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: TerminateInstance
+//--------------------------------------------------------------------------
+
+// TerminateInstance deletes the device of the node.
+func (d *Data) TerminateInstance(n *cluster.Node) error {
+
+	if _, err := d.client.Devices.Delete(n.ID); err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return err
+	}
+
+	log.WithFields(log.Fields{"cmd": "pkt:" + d.command, "id": n.ID}).
+		Info("Device deleted")
+
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: ListInstances
+//--------------------------------------------------------------------------
+
+// ListInstances returns the devices of the project named after the domain.
+func (d *Data) ListInstances() ([]*cluster.Node, error) {
+
+	devices, _, err := d.client.Devices.List(d.ProjectID)
+	if err != nil {
+		log.WithField("cmd", "pkt:"+d.command).Error(err)
+		return nil, err
+	}
+
+	nodes := []*cluster.Node{}
+	for _, dev := range devices {
+
+		// Skip foreign and leaving devices:
+		name := strings.TrimSuffix(dev.Hostname, "."+d.Domain)
+		sep := strings.LastIndex(name, "-")
+		if name == dev.Hostname || sep < 0 || dev.State == "deprovisioning" {
+			continue
+		}
+
+		n := &cluster.Node{
+			ID:       dev.ID,
+			HostName: name[:sep],
+			HostID:   name[sep+1:],
+			Roles:    dev.Tags,
+		}
+
+		if dev.Plan != nil {
+			n.InstanceType = dev.Plan.Slug
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+//--------------------------------------------------------------------------
+// func: InstanceIPs
+//--------------------------------------------------------------------------
+
+// InstanceIPs waits for the IPv4 addresses of the node.
+func (d *Data) InstanceIPs(n *cluster.Node) (*cluster.IPs, error) {
+
+	ips := &cluster.IPs{}
+
+	// Retry loop (addresses are assigned while provisioning):
+	for i := 0; i < 30; i++ {
+
+		device, _, err := d.client.Devices.Get(n.ID)
+		if err != nil {
+			log.WithField("cmd", "pkt:"+d.command).Error(err)
+			return nil, err
+		}
+
+		for _, ip := range device.Network {
+			if ip.AddressFamily != 4 {
+				continue
+			}
+			if ip.Public {
+				ips.External = ip.Address
+			} else {
+				ips.Internal = ip.Address
+			}
+		}
+
+		if ips.Internal != "" {
+			return ips, nil
+		}
+
+		// Sleep and try again:
+		time.Sleep(10 * time.Second)
+	}
+
+	return nil, errors.New("Timeout waiting for the device addresses")
+}
+
+//--------------------------------------------------------------------------
+// func: AttachBalancer
+//--------------------------------------------------------------------------
+
+// AttachBalancer does nothing, Packet.net has no load balancer API.
+func (d *Data) AttachBalancer(n *cluster.Node) error {
+	log.WithFields(log.Fields{"cmd": "pkt:" + d.command, "id": n.ID}).
+		Info("No load balancer on Packet.net, skipping")
+	return nil
+}
+
+//--------------------------------------------------------------------------
+// func: SaveState
+//--------------------------------------------------------------------------
+
+// SaveState dumps the state with the cluster data decided by the orchestrator.
+func (d *Data) SaveState(c *cluster.Cluster) error {
+	d.EtcdToken = c.EtcdToken
+	d.QuorumCount = c.QuorumCount
+	d.MasterCount = c.MasterCount
+	d.DNSZones = c.DNSZones
+	return kato.DumpState(d.State, d.ClusterID)
+}
+
+//--------------------------------------------------------------------------
+// func: clusterData
+//--------------------------------------------------------------------------
+
+// clusterData returns the provider agnostic part of the state.
+func (d *Data) clusterData() *cluster.Cluster {
+	return &cluster.Cluster{
+		ClusterID:    d.ClusterID,
+		Domain:       d.Domain,
+		Quadruplets:  d.Quadruplets,
+		QuorumCount:  d.QuorumCount,
+		MasterCount:  d.MasterCount,
+		EtcdToken:    d.EtcdToken,
+		DNSProvider:  d.DNSProvider,
+		DNSApiKey:    d.DNSApiKey,
+		CalicoIPPool: d.CalicoIPPool,
+		CaCertPath:   d.CaCertPath,
+		StubZones:    d.StubZones,
+		SlackWebhook: d.SlackWebhook,
+		SMTPURL:      d.SMTPURL,
+		AdminEmail:   d.AdminEmail,
+		SecretsURL:   d.SecretsURL,
+		DNSZones:     d.DNSZones,
+	}
+}
@@ -0,0 +1,161 @@
+package r53
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+
+	// AWS SDK:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// func: init
+//-----------------------------------------------------------------------------
+
+func init() {
+	dns.Register("r53", func(apiKey string) dns.Provider {
+		return &Data{APIKey: apiKey}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: ShowRecords
+//-----------------------------------------------------------------------------
+
+// ShowRecords prints the records of a Route 53 zone matching the name and
+// type filters.
+func (d *Data) ShowRecords() {
+	zone := *d.Zone.HostedZone.Name
+	if err := dns.Show(d, zone, d.Name, d.Type, d.Output); err != nil {
+		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: CreatePrivateZones
+//-----------------------------------------------------------------------------
+
+// CreatePrivateZones adds zones to Route 53 which only resolve from inside
+// the given VPC. Existing private zones are left as they are.
+func (d *Data) CreatePrivateZones(vpcID, region string, zones ...string) error {
+	d.Private, d.VpcID, d.Region = true, vpcID, region
+	return d.CreateZones(zones...)
+}
+
+//-----------------------------------------------------------------------------
+// func: ListZones
+//-----------------------------------------------------------------------------
+
+// ListZones returns the names of all the public Route 53 zones, or of the
+// private ones if asked to.
+func (d *Data) ListZones() ([]string, error) {
+
+	// Set the current command:
+	d.command = "zone:list"
+	d.client()
+
+	// Send the zone list requests:
+	zones := []string{}
+	err := d.r53.ListHostedZonesPages(&route53.ListHostedZonesInput{},
+		func(page *route53.ListHostedZonesOutput, lastPage bool) bool {
+			for _, z := range page.HostedZones {
+				if isPrivate(z) == d.Private {
+					zones = append(zones, strings.TrimSuffix(*z.Name, "."))
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return zones, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ListRecords
+//-----------------------------------------------------------------------------
+
+// ListRecords returns all the record sets of a Route 53 zone. Alias records
+// list their target as data.
+func (d *Data) ListRecords(zone string) ([]dns.Record, error) {
+
+	// Set the current command:
+	d.command = "record:list"
+
+	// Get the zone data:
+	if err := d.useZone(zone); err != nil {
+		return nil, err
+	}
+
+	// Send the record list requests:
+	records := []dns.Record{}
+	params := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String(*d.Zone.Id),
+	}
+
+	err := d.r53.ListResourceRecordSetsPages(params,
+		func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, set := range page.ResourceRecordSets {
+				r := dns.Record{
+					Name: dns.RelativeName(*set.Name, *d.Zone.HostedZone.Name),
+					Type: *set.Type,
+				}
+				if set.TTL != nil {
+					r.TTL = int(*set.TTL)
+				}
+				for _, rr := range set.ResourceRecords {
+					value := *rr.Value
+					if r.Type == "TXT" {
+						value = strings.Replace(strings.Trim(value, `"`), `\"`, `"`, -1)
+					}
+					r.Data = append(r.Data, value)
+				}
+				if set.AliasTarget != nil {
+					r.Data = append(r.Data, *set.AliasTarget.DNSName)
+				}
+				records = append(records, r)
+			}
+			return true
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: NameServers
+//-----------------------------------------------------------------------------
+
+// NameServers returns the Route 53 name servers of zone.
+func (d *Data) NameServers(zone string) ([]string, error) {
+
+	// Get the zone data (name servers included):
+	if err := d.useZone(zone); err != nil {
+		return nil, err
+	}
+
+	ns := []string{}
+	for _, record := range d.Zone.ResourceRecords {
+		ns = append(ns, *record.Value)
+	}
+
+	return ns, nil
+}
@@ -18,6 +18,8 @@ var (
 	// r53 zone/record:
 	cmdR53       = cli.App.Command("r53", "Manages Route 53 zones and records.")
 	flR53APIKey  = cmdR53.Flag("api-key", "R53 private API key.").String()
+	flR53Private = cmdR53.Flag("private",
+		"Use private zones, bound to a VPC.").Bool()
 	cmdR53Zone   = cmdR53.Command("zone", "Manage Route 53 zones.")
 	cmdR53Record = cmdR53.Command("record", "Manage Route 53 records.")
 
@@ -25,18 +27,92 @@ var (
 	cmdR53ZoneAdd    = cmdR53Zone.Command("add", "Adds Route 53 zones.")
 	arR53ZoneAddName = cmdR53ZoneAdd.Arg("fqdn",
 		"List of zones to publish.").Required().Strings()
+	flR53ZoneAddParent = cmdR53ZoneAdd.Flag("parent-provider",
+		"Delegate from a parent zone of this provider [ ns1 | r53 | rfc2136 ]").String()
+	flR53ZoneAddParentKey = cmdR53ZoneAdd.Flag("parent-api-key",
+		"API key of the parent provider.").String()
+	flR53ZoneAddVpcID = cmdR53ZoneAdd.Flag("vpc-id",
+		"VPC of the private zones.").String()
+	flR53ZoneAddRegion = cmdR53ZoneAdd.Flag("vpc-region",
+		"Region of the VPC.").String()
 
 	// r53 zone del:
 	cmdR53ZoneDel    = cmdR53Zone.Command("del", "Deletes Route 53 zones.")
 	arR53ZoneDelName = cmdR53ZoneDel.Arg("fqdn",
 		"List of zones to delete.").Required().Strings()
+	flR53ZoneDelRecursive = cmdR53ZoneDel.Flag("recursive",
+		"Purge records, child zones and the parent delegation first.").Bool()
+	flR53ZoneDelParent = cmdR53ZoneDel.Flag("parent-provider",
+		"Remove the delegation from this provider [ ns1 | r53 | rfc2136 ]").String()
+	flR53ZoneDelParentKey = cmdR53ZoneDel.Flag("parent-api-key",
+		"API key of the parent provider.").String()
 
 	// r53 record add:
 	cmdR53RecordAdd    = cmdR53Record.Command("add", "Adds records to Route 53 zones.")
 	flR53RecordAddZone = cmdR53RecordAdd.Flag("zone",
 		"DNS zone where records are added.").Required().String()
 	arR53RecordAddName = cmdR53RecordAdd.Arg("record",
-		"List of name:type:data records.").Required().Strings()
+		"List of 'name [ttl] type data', JSON or name:type:data records.").
+		Required().Strings()
+
+	flR53RecordAddTTL = cmdR53RecordAdd.Flag("ttl",
+		"TTL of the records without one.").Default("300").Int()
+
+	flR53RecordAddPolicy = cmdR53RecordAdd.Flag("policy",
+		"Routing policy [ simple | weighted | failover | latency ]").
+		Default("simple").Enum("simple", "weighted", "failover", "latency")
+
+	flR53RecordAddSetID = cmdR53RecordAdd.Flag("set-id",
+		"Set identifier, required by the non simple policies.").String()
+
+	flR53RecordAddWeight = cmdR53RecordAdd.Flag("weight",
+		"Weight of the records [ 0..255 ]").Default("1").Int64()
+
+	flR53RecordAddFailover = cmdR53RecordAdd.Flag("failover",
+		"Failover role of the records [ primary | secondary ]").
+		Enum("primary", "secondary")
+
+	flR53RecordAddRegion = cmdR53RecordAdd.Flag("latency-region",
+		"AWS region the latency is measured against.").String()
+
+	flR53RecordAddAliasELB = cmdR53RecordAdd.Flag("alias-elb",
+		"Alias the named records to the ELB of this cluster ID.").
+		PlaceHolder("CLUSTER_ID").String()
+
+	flR53RecordAddAliasTarget = cmdR53RecordAdd.Flag("alias-target",
+		"Alias the named records to this DNS name.").String()
+
+	flR53RecordAddAliasZoneID = cmdR53RecordAdd.Flag("alias-zone-id",
+		"Hosted zone ID of the alias target.").String()
+
+	flR53RecordAddHealthCheck = cmdR53RecordAdd.Flag("health-check",
+		"Check the record address [ http | https | tcp ]:port[/path]").
+		PlaceHolder("PROTO:PORT").String()
+
+	flR53RecordAddHealthCheckID = cmdR53RecordAdd.Flag("health-check-id",
+		"Use an existing health check.").String()
+
+	// r53 record del:
+	cmdR53RecordDel    = cmdR53Record.Command("del", "Deletes records from Route 53 zones.")
+	flR53RecordDelZone = cmdR53RecordDel.Flag("zone",
+		"DNS zone where records are deleted.").Required().String()
+	arR53RecordDelName = cmdR53RecordDel.Arg("record",
+		"List of name:type records.").Required().Strings()
+	flR53RecordDelSetID = cmdR53RecordDel.Flag("set-id",
+		"Only delete the set with this identifier.").String()
+
+	// r53 record list:
+	cmdR53RecordList = cmdR53Record.Command("list",
+		"Lists records of Route 53 zones, exits 2 if none matches.")
+	flR53RecordListZone = cmdR53RecordList.Flag("zone",
+		"DNS zone where records are listed.").Required().String()
+	flR53RecordListName = cmdR53RecordList.Flag("name",
+		"Only records whose name matches this glob.").String()
+	flR53RecordListType = cmdR53RecordList.Flag("type",
+		"Only records of this type.").String()
+	flR53RecordListOutput = cmdR53RecordList.Flag("output",
+		"Output format [ text | json ]").
+		Default("text").Enum("text", "json")
 )
 
 //-----------------------------------------------------------------------------
@@ -51,16 +127,27 @@ func RunCmd(cmd string) bool {
 	// katoctl r53 zone add:
 	case cmdR53ZoneAdd.FullCommand():
 		d := Data{
-			APIKey: *flR53APIKey,
-			Zones:  *arR53ZoneAddName,
+			APIKey:  *flR53APIKey,
+			Zones:   *arR53ZoneAddName,
+			Private: *flR53Private,
+			VpcID:   *flR53ZoneAddVpcID,
+			Region:  *flR53ZoneAddRegion,
+
+			ParentProvider: *flR53ZoneAddParent,
+			ParentAPIKey:   *flR53ZoneAddParentKey,
 		}
 		d.AddZones()
 
 	// katoctl r53 zone del:
 	case cmdR53ZoneDel.FullCommand():
 		d := Data{
-			APIKey: *flR53APIKey,
-			Zones:  *arR53ZoneDelName,
+			APIKey:  *flR53APIKey,
+			Zones:   *arR53ZoneDelName,
+			Private: *flR53Private,
+
+			Recursive:      *flR53ZoneDelRecursive,
+			ParentProvider: *flR53ZoneDelParent,
+			ParentAPIKey:   *flR53ZoneDelParentKey,
 		}
 		d.DelZones()
 
@@ -74,9 +161,56 @@ func RunCmd(cmd string) bool {
 				},
 			},
 			Records: *arR53RecordAddName,
+			Private: *flR53Private,
+			Routing: Routing{
+				TTL:           *flR53RecordAddTTL,
+				Policy:        *flR53RecordAddPolicy,
+				SetID:         *flR53RecordAddSetID,
+				Weight:        *flR53RecordAddWeight,
+				Failover:      *flR53RecordAddFailover,
+				LatencyRegion: *flR53RecordAddRegion,
+				AliasELB:      *flR53RecordAddAliasELB,
+				AliasTarget:   *flR53RecordAddAliasTarget,
+				AliasZoneID:   *flR53RecordAddAliasZoneID,
+				HealthCheck:   *flR53RecordAddHealthCheck,
+				HealthCheckID: *flR53RecordAddHealthCheckID,
+			},
 		}
 		d.AddRecords()
 
+	// katoctl r53 record del:
+	case cmdR53RecordDel.FullCommand():
+		d := Data{
+			APIKey: *flR53APIKey,
+			Zone: zoneData{
+				HostedZone: route53.HostedZone{
+					Name: flR53RecordDelZone,
+				},
+			},
+			Records: *arR53RecordDelName,
+			Private: *flR53Private,
+			Routing: Routing{
+				SetID: *flR53RecordDelSetID,
+			},
+		}
+		d.DelRecords()
+
+	// katoctl r53 record list:
+	case cmdR53RecordList.FullCommand():
+		d := Data{
+			APIKey: *flR53APIKey,
+			Zone: zoneData{
+				HostedZone: route53.HostedZone{
+					Name: flR53RecordListZone,
+				},
+			},
+			Name:    *flR53RecordListName,
+			Type:    *flR53RecordListType,
+			Output:  *flR53RecordListOutput,
+			Private: *flR53Private,
+		}
+		d.ShowRecords()
+
 	// Nothing to do:
 	default:
 		return false
@@ -7,8 +7,7 @@ package r53
 import (
 
 	// Stdlib:
-	"os"
-	"os/exec"
+	"errors"
 	"strings"
 	"time"
 
@@ -19,6 +18,9 @@ import (
 
 	// Community:
 	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
 )
 
 //-----------------------------------------------------------------------------
@@ -38,6 +40,17 @@ type Data struct {
 	Zone    zoneData
 	Records []string
 	Zones   []string
+	Name    string
+	Type    string
+	Output  string
+	Routing Routing
+	Private bool   // Private zone, public if false
+	VpcID   string // VPC of a new private zone
+	Region  string // Region of the VPC
+
+	ParentProvider string // Delegate from a parent zone of this provider
+	ParentAPIKey   string // API key of the parent provider
+	Recursive      bool   // Purge records and child zones on deletion
 }
 
 //-----------------------------------------------------------------------------
@@ -46,51 +59,184 @@ type Data struct {
 
 // AddRecords adds one or more records to a Route 53 zone.
 func (d *Data) AddRecords() {
+	zone := *d.Zone.HostedZone.Name
+	if err := d.CreateRecords(zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelRecords
+//-----------------------------------------------------------------------------
+
+// DelRecords deletes one or more records from a Route 53 zone.
+func (d *Data) DelRecords() {
+	zone := *d.Zone.HostedZone.Name
+	for _, record := range d.Records {
+		if err := d.DeleteRecords(zone, record); err != nil {
+			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": record}).
+				Fatal(err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: AddZones
+//-----------------------------------------------------------------------------
+
+// AddZones adds one or more zones to Route 53. The public zones without a
+// parent in Route 53 are delegated from the parent provider, if any.
+func (d *Data) AddZones() {
+	for _, zone := range d.Zones {
+		if err := d.CreateZones(zone); err != nil {
+			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
+				Fatal(err)
+		}
+	}
+
+	if d.Private {
+		return
+	}
+
+	if err := dns.DelegateAcross(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": d.ParentProvider}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelZones
+//-----------------------------------------------------------------------------
+
+// DelZones deletes one or more zones from Route 53. Recursive deletions purge
+// the records, child zones and parent delegations first.
+func (d *Data) DelZones() {
+
+	if d.Recursive {
+		d.command = "zone:del"
+		if err := dns.Purge(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+			log.WithField("cmd", "r53:"+d.command).Fatal(err)
+		}
+		return
+	}
+
+	for _, zone := range d.Zones {
+		if err := d.DeleteZones(zone); err != nil {
+			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
+				Fatal(err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: CreateRecords
+//-----------------------------------------------------------------------------
+
+// CreateRecords creates or updates records in a Route 53 zone. See
+// dns.ParseRecords for the accepted record specs and Routing for the Route
+// 53 only settings applied to them.
+func (d *Data) CreateRecords(zone string, records ...string) error {
 
 	// Set the current command:
 	d.command = "record:add"
 
-	// Create the service handler:
-	d.r53 = route53.New(session.Must(session.NewSession()))
+	// Parse and validate all the records first:
+	sets, err := d.recordSets(records)
+	if err != nil {
+		return err
+	}
 
 	// Get the zone data:
-	zone := normalizeZoneName(*d.Zone.HostedZone.Name)
-	*d.Zone.HostedZone.Name = zone
-	if _, err := d.getZone(zone); err != nil {
-		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
-			Fatal(err)
+	if err := d.useZone(zone); err != nil {
+		return err
 	}
 
-	// Return if zone is missing:
-	if d.Zone.Id == nil || *d.Zone.Id == "" {
-		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
-			Fatal("Ops! This zone does not exist")
+	// For each requested record:
+	for _, r := range sets {
+		if err := d.addRecord(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: DeleteRecords
+//-----------------------------------------------------------------------------
+
+// DeleteRecords deletes name:type records from a Route 53 zone. The
+// deletions are sent in batches of up to 100 record sets.
+func (d *Data) DeleteRecords(zone string, records ...string) error {
+
+	// Set the current command:
+	d.command = "record:del"
+
+	// Get the zone data:
+	if err := d.useZone(zone); err != nil {
+		return err
 	}
 
 	// For each requested record:
-	for _, record := range d.Records {
-		if err := d.addRecord(record); err != nil {
-			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": record}).
-				Fatal(err)
+	changes := []*route53.Change{}
+	for _, record := range records {
+		c, err := d.delRecord(record)
+		if err != nil {
+			return err
 		}
+		changes = append(changes, c...)
 	}
+
+	// Send the change requests:
+	for i := 0; i < len(changes); i += 100 {
+
+		batch := changes[i:]
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+
+		if _, err := d.r53.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(*d.Zone.Id),
+			ChangeBatch:  &route53.ChangeBatch{Changes: batch},
+		}); err != nil {
+			return err
+		}
+
+		// Log record deletion:
+		for _, c := range batch {
+			log.WithFields(log.Fields{"cmd": "r53:" + d.command,
+				"id": *c.ResourceRecordSet.Name}).Info("DNS record deleted")
+		}
+	}
+
+	// Delete the health checks no longer in use:
+	for _, c := range changes {
+		if id := c.ResourceRecordSet.HealthCheckId; id != nil {
+			if err := d.deleteHealthCheck(*id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: AddZones
+// func: CreateZones
 //-----------------------------------------------------------------------------
 
-// AddZones adds one or more zones to Route 53.
-func (d *Data) AddZones() {
+// CreateZones adds zones to Route 53 and delegates them from their parent
+// zone, if any. Existing zones are left as they are. Private zones are bound
+// to the VPC and never delegated.
+func (d *Data) CreateZones(zones ...string) error {
 
 	// Set the current command:
 	d.command = "zone:add"
-
-	// Create the service handler:
-	d.r53 = route53.New(session.Must(session.NewSession()))
+	d.client()
 
 	// For each requested zone:
-	for _, zone := range d.Zones {
+	for _, zone := range zones {
 
 		// Normalize the zone name:
 		zone = normalizeZoneName(zone)
@@ -98,45 +244,48 @@ func (d *Data) AddZones() {
 
 		// Add the child zone:
 		if err := d.addZone(); err != nil {
-			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
-				Fatal(err)
+			return err
+		}
+
+		// Private zones are never delegated:
+		if d.Private {
+			d.Zone.Id = nil
+			continue
 		}
 
 		// Get the parent zone:
 		pZone, err := d.getParentZone()
 		if err != nil {
-			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
-				Fatal(err)
+			return err
 		}
 
 		// If any:
 		if pZone != "" {
 			if err := d.delegateZone(pZone); err != nil {
-				log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": zone}).
-					Fatal(err)
+				return err
 			}
 		}
 
 		// Clean zone data:
 		d.Zone.Id = nil
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: DelZones
+// func: DeleteZones
 //-----------------------------------------------------------------------------
 
-// DelZones deletes one or more zones from Route 53.
-func (d *Data) DelZones() {
+// DeleteZones deletes zones from Route 53.
+func (d *Data) DeleteZones(zones ...string) error {
 
 	// Set the current command:
 	d.command = "zone:del"
-
-	// Create the service handler:
-	d.r53 = route53.New(session.Must(session.NewSession()))
+	d.client()
 
 	// For each requested zone:
-	for _, zone := range d.Zones {
+	for _, zone := range zones {
 
 		// Normalize the zone name:
 		zone = normalizeZoneName(zone)
@@ -144,42 +293,100 @@ func (d *Data) DelZones() {
 
 		// Delete the child zone:
 		if err := d.delZone(); err != nil {
-			log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": d.Zone}).
-				Fatal(err)
+			return err
 		}
+
+		// Clean zone data:
+		d.Zone.Id = nil
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// func: addRecord
+// func: client
 //-----------------------------------------------------------------------------
 
-func (d *Data) addRecord(record string) error {
+// client creates the Route 53 service handler once.
+func (d *Data) client() {
+	if d.r53 == nil {
+		d.r53 = route53.New(session.Must(session.NewSession()))
+	}
+}
 
-	// Split into name:type:data
-	s := strings.Split(record, ":")
-	resourceName := s[0]
-	resourceType := s[1]
-	resourceData := s[2]
+//-----------------------------------------------------------------------------
+// func: useZone
+//-----------------------------------------------------------------------------
+
+// useZone loads the data of an existing zone.
+func (d *Data) useZone(zone string) error {
+
+	d.client()
+
+	// Get the zone data:
+	zone = normalizeZoneName(zone)
+	d.Zone.HostedZone.Name = &zone
+	d.Zone.Id = nil
+	if _, err := d.getZone(zone); err != nil {
+		return err
+	}
+
+	// Fail if zone is missing:
+	if d.Zone.Id == nil || *d.Zone.Id == "" {
+		return errors.New("Ops! This zone does not exist: " + zone)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: addRecord
+//-----------------------------------------------------------------------------
+
+func (d *Data) addRecord(r dns.Record) error {
 
 	// Resource records (innermost matryoshka):
 	resourceRecords := []*route53.ResourceRecord{}
-	for _, resource := range strings.Split(resourceData, ",") {
+	for _, resource := range r.Data {
+		if r.Type == "TXT" {
+			resource = `"` + strings.Replace(resource, `"`, `\"`, -1) + `"`
+		}
 		resourceRecords = append(resourceRecords, &route53.ResourceRecord{
 			Value: aws.String(resource),
 		})
 	}
 
-	// Changes (middle matryoshka):
+	// The apex is '@' and the TTL defaults to 300:
 	zone := *d.Zone.HostedZone.Name
+	name := r.Name + "." + zone
+	if r.Name == "@" {
+		name = zone
+	}
+
+	ttl := int64(300)
+	if d.Routing.TTL > 0 {
+		ttl = int64(d.Routing.TTL)
+	}
+	if r.TTL > 0 {
+		ttl = int64(r.TTL)
+	}
+
+	// Record set with its alias, routing policy and health check:
+	set := &route53.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            aws.String(r.Type),
+		TTL:             aws.Int64(ttl),
+		ResourceRecords: resourceRecords,
+	}
+
+	if err := d.route(set, r); err != nil {
+		return err
+	}
+
+	// Changes (middle matryoshka):
 	changes := []*route53.Change{{
-		Action: aws.String("UPSERT"),
-		ResourceRecordSet: &route53.ResourceRecordSet{
-			Name:            aws.String(resourceName + "." + zone),
-			Type:            aws.String(resourceType),
-			TTL:             aws.Int64(300),
-			ResourceRecords: resourceRecords,
-		},
+		Action:            aws.String("UPSERT"),
+		ResourceRecordSet: set,
 	}}
 
 	// Forge the change request (outermost matryoshka):
@@ -196,12 +403,71 @@ func (d *Data) addRecord(record string) error {
 	}
 
 	// Log record creation:
-	log.WithFields(log.Fields{"cmd": "r53:" + d.command,
-		"id": resourceName + "." + zone}).Info("DNS record created/updated")
+	log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": name}).
+		Info("DNS record created/updated")
 
 	return nil
 }
 
+//-----------------------------------------------------------------------------
+// func: delRecord
+//-----------------------------------------------------------------------------
+
+// delRecord returns the changes deleting the sets of a name:type record.
+func (d *Data) delRecord(record string) ([]*route53.Change, error) {
+
+	// Split into name:type
+	s := strings.Split(record, ":")
+	if len(s) != 2 {
+		return nil, errors.New("Invalid record: " + record)
+	}
+
+	// The apex is '@' as in 'record list':
+	zone := *d.Zone.HostedZone.Name
+	name, rtype := s[0]+"."+zone, strings.ToUpper(s[1])
+	if s[0] == "@" {
+		name = zone
+	}
+
+	// Forge the record list request (one set per routing policy entry):
+	params := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(*d.Zone.Id),
+		MaxItems:        aws.String("100"),
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String(rtype),
+	}
+
+	// Send the record list request:
+	resp, err := d.r53.ListResourceRecordSets(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deletions must match the current sets:
+	changes := []*route53.Change{}
+	for _, set := range resp.ResourceRecordSets {
+		if *set.Name != name || *set.Type != rtype {
+			break
+		}
+		if d.Routing.SetID != "" &&
+			(set.SetIdentifier == nil || *set.SetIdentifier != d.Routing.SetID) {
+			continue
+		}
+		changes = append(changes, &route53.Change{
+			Action:            aws.String("DELETE"),
+			ResourceRecordSet: set,
+		})
+	}
+
+	// Log if the record is missing:
+	if len(changes) == 0 {
+		log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": name}).
+			Info("Ops! this record does not exist")
+	}
+
+	return changes, nil
+}
+
 //-----------------------------------------------------------------------------
 // func: addZone
 //-----------------------------------------------------------------------------
@@ -214,8 +480,8 @@ func (d *Data) addZone() error {
 		return err
 	}
 
-	// If the zone is missing:
-	if d.Zone.Id == nil || *d.Zone.Id == "" {
+	// If the zone is missing (a public and a private zone may share names):
+	if d.Zone.Id == nil || *d.Zone.Id == "" || isPrivate(&d.Zone.HostedZone) != d.Private {
 
 		// Forge the new zone request:
 		params := &route53.CreateHostedZoneInput{
@@ -223,6 +489,20 @@ func (d *Data) addZone() error {
 			Name:            aws.String(zone),
 		}
 
+		// Bind private zones to the VPC:
+		if d.Private {
+			if d.VpcID == "" || d.Region == "" {
+				return errors.New("Private zones require a VPC ID and its region")
+			}
+			params.HostedZoneConfig = &route53.HostedZoneConfig{
+				PrivateZone: aws.Bool(true),
+			}
+			params.VPC = &route53.VPC{
+				VPCId:     aws.String(d.VpcID),
+				VPCRegion: aws.String(d.Region),
+			}
+		}
+
 		// Send the new zone request:
 		if _, err := d.r53.CreateHostedZone(params); err != nil {
 			return err
@@ -332,7 +612,7 @@ func (d *Data) getZone(zone string) (string, error) {
 	// Forge the zone list request:
 	pZone := &route53.ListHostedZonesByNameInput{
 		DNSName:  aws.String(zone),
-		MaxItems: aws.String("1"),
+		MaxItems: aws.String("10"),
 	}
 
 	// Send the zone list request:
@@ -341,8 +621,19 @@ func (d *Data) getZone(zone string) (string, error) {
 		return "", err
 	}
 
+	// Prefer the zone with the requested visibility:
+	var hz *route53.HostedZone
+	for _, z := range rZone.HostedZones {
+		if *z.Name != zone {
+			break
+		}
+		if hz == nil || (isPrivate(z) == d.Private && isPrivate(hz) != d.Private) {
+			hz = z
+		}
+	}
+
 	// Zone does not exist:
-	if len(rZone.HostedZones) < 1 || *rZone.HostedZones[0].Name != zone {
+	if hz == nil {
 		return "", nil
 	}
 
@@ -351,7 +642,7 @@ func (d *Data) getZone(zone string) (string, error) {
 
 		// Forge the NS record list request:
 		pRsrc := &route53.ListResourceRecordSetsInput{
-			HostedZoneId:    aws.String(*rZone.HostedZones[0].Id),
+			HostedZoneId:    aws.String(*hz.Id),
 			MaxItems:        aws.String("1"),
 			StartRecordName: aws.String(zone),
 			StartRecordType: aws.String("NS"),
@@ -364,11 +655,11 @@ func (d *Data) getZone(zone string) (string, error) {
 		}
 
 		// Save the data:
-		d.Zone.HostedZone = *rZone.HostedZones[0]
+		d.Zone.HostedZone = *hz
 		d.Zone.ResourceRecordSet = *rRsrc.ResourceRecordSets[0]
 	}
 
-	return *rZone.HostedZones[0].Id, nil
+	return *hz.Id, nil
 }
 
 //-----------------------------------------------------------------------------
@@ -383,18 +674,18 @@ func (d *Data) delegateZone(pZone string) error {
 		ns = append(ns, *record.Value)
 	}
 
-	// Forge the 'record add' command:
+	// Add the NS records to the parent zone:
 	zone := strings.Replace(*d.Zone.HostedZone.Name, "."+pZone, "", 1)
-	cmd := exec.Command("katoctl", "r53", "record", "add",
-		"--zone", pZone, zone+":NS:"+strings.Join(ns, ","))
+	p := &Data{r53: d.r53}
+	return p.CreateRecords(pZone, zone+":NS:"+strings.Join(ns, ","))
+}
 
-	// Execute the 'record add' command:
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+//-----------------------------------------------------------------------------
+// func: isPrivate
+//-----------------------------------------------------------------------------
 
-	return nil
+func isPrivate(zone *route53.HostedZone) bool {
+	return zone.Config != nil && zone.Config.PrivateZone != nil && *zone.Config.PrivateZone
 }
 
 //-----------------------------------------------------------------------------
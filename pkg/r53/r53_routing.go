@@ -0,0 +1,368 @@
+package r53
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// AWS SDK:
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/route53"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Routing holds the Route 53 only settings of the records being added.
+type Routing struct {
+	TTL           int    // Default TTL of the records without one
+	Policy        string // simple | weighted | failover | latency
+	SetID         string // Set identifier, required by non simple policies
+	Weight        int64  // Weighted policy: 0..255
+	Failover      string // Failover policy: primary | secondary
+	LatencyRegion string // Latency policy: AWS region of the endpoint
+	AliasELB      string // Alias to the ELB of this cluster ID
+	AliasTarget   string // Alias to this DNS name...
+	AliasZoneID   string // ...in this hosted zone ID
+	HealthCheck   string // Creates a proto:port[/path] health check
+	HealthCheckID string // Uses an existing health check
+}
+
+//-----------------------------------------------------------------------------
+// func: validate
+//-----------------------------------------------------------------------------
+
+func (rt *Routing) validate() error {
+
+	// Routing policy:
+	switch rt.Policy {
+	case "", "simple":
+		if rt.SetID != "" {
+			return errors.New("A set identifier requires a routing policy")
+		}
+	case "weighted":
+		if rt.Weight < 0 || rt.Weight > 255 {
+			return errors.New("Weights go from 0 to 255")
+		}
+	case "failover":
+		if rt.Failover == "" {
+			return errors.New("The failover policy requires primary or secondary")
+		}
+	case "latency":
+		if rt.LatencyRegion == "" {
+			return errors.New("The latency policy requires a region")
+		}
+	default:
+		return errors.New("Unsupported routing policy: " + rt.Policy)
+	}
+
+	if rt.Policy != "" && rt.Policy != "simple" && rt.SetID == "" {
+		return errors.New("The " + rt.Policy + " policy requires a set identifier")
+	}
+
+	// Alias:
+	if rt.AliasELB != "" && rt.AliasTarget != "" {
+		return errors.New("Alias to an ELB or to a target, not both")
+	}
+
+	if rt.AliasTarget != "" && rt.AliasZoneID == "" {
+		return errors.New("Alias targets require their hosted zone ID")
+	}
+
+	// Health check:
+	if rt.HealthCheck != "" && rt.HealthCheckID != "" {
+		return errors.New("Create a health check or use an existing one, not both")
+	}
+
+	if rt.aliased() && rt.HealthCheck != "" {
+		return errors.New("Alias records evaluate the health of their target")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: aliased
+//-----------------------------------------------------------------------------
+
+func (rt *Routing) aliased() bool {
+	return rt.AliasELB != "" || rt.AliasTarget != ""
+}
+
+//-----------------------------------------------------------------------------
+// func: recordSets
+//-----------------------------------------------------------------------------
+
+// recordSets parses the record specs, alias records are given by name only.
+func (d *Data) recordSets(specs []string) ([]dns.Record, error) {
+
+	if err := d.Routing.validate(); err != nil {
+		return nil, err
+	}
+
+	if !d.Routing.aliased() {
+		return dns.ParseRecords(specs...)
+	}
+
+	sets := []dns.Record{}
+	for _, name := range specs {
+		if strings.ContainsAny(name, " :{") {
+			return nil, errors.New("Alias records are given by name only: " + name)
+		}
+		sets = append(sets, dns.Record{Name: name, Type: "A"})
+	}
+
+	return sets, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: route
+//-----------------------------------------------------------------------------
+
+// route applies the alias, the routing policy and the health check to set.
+func (d *Data) route(set *route53.ResourceRecordSet, r dns.Record) error {
+
+	rt := d.Routing
+
+	// Alias records have no TTL nor resource records:
+	if rt.aliased() {
+		target, zoneID, err := d.aliasTarget()
+		if err != nil {
+			return err
+		}
+		set.TTL, set.ResourceRecords = nil, nil
+		set.AliasTarget = &route53.AliasTarget{
+			DNSName:              aws.String(target),
+			HostedZoneId:         aws.String(zoneID),
+			EvaluateTargetHealth: aws.Bool(true),
+		}
+	}
+
+	// Routing policy:
+	if rt.SetID != "" {
+		set.SetIdentifier = aws.String(rt.SetID)
+	}
+
+	switch rt.Policy {
+	case "weighted":
+		set.Weight = aws.Int64(rt.Weight)
+	case "failover":
+		set.Failover = aws.String(strings.ToUpper(rt.Failover))
+	case "latency":
+		set.Region = aws.String(rt.LatencyRegion)
+	}
+
+	// Health check:
+	switch {
+	case rt.HealthCheckID != "":
+		set.HealthCheckId = aws.String(rt.HealthCheckID)
+	case rt.HealthCheck != "":
+		id, err := d.createHealthCheck(*set.Name, r)
+		if err != nil {
+			return err
+		}
+		set.HealthCheckId = aws.String(id)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: aliasTarget
+//-----------------------------------------------------------------------------
+
+// aliasTarget returns the DNS name and the hosted zone ID of the alias target.
+// The ELB of a cluster is found through the DNSName of its state.
+func (d *Data) aliasTarget() (string, string, error) {
+
+	if d.Routing.AliasTarget != "" {
+		return d.Routing.AliasTarget, d.Routing.AliasZoneID, nil
+	}
+
+	// Read the cluster state:
+	raw, err := kato.ReadState(d.Routing.AliasELB)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := struct{ ClusterID, Region, DNSName string }{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return "", "", err
+	}
+
+	if state.DNSName == "" {
+		return "", "", errors.New("No ELB found in the state of " + d.Routing.AliasELB)
+	}
+
+	// The ELB is named after the cluster:
+	svc := elb.New(session.New(&aws.Config{Region: aws.String(state.Region)}))
+	resp, err := svc.DescribeLoadBalancers(&elb.DescribeLoadBalancersInput{
+		LoadBalancerNames: []*string{aws.String(state.ClusterID)},
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(resp.LoadBalancerDescriptions) == 0 {
+		return "", "", errors.New("ELB not found: " + state.ClusterID)
+	}
+
+	return state.DNSName, *resp.LoadBalancerDescriptions[0].CanonicalHostedZoneNameID, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: createHealthCheck
+//-----------------------------------------------------------------------------
+
+// createHealthCheck checks the address of a single A record. Adding the
+// record again reuses the existing check, found by the digest prefix of its
+// caller reference. Route 53 never takes the caller reference of a deleted
+// check again so a timestamp keeps every new reference unique.
+func (d *Data) createHealthCheck(name string, r dns.Record) (string, error) {
+
+	// Split into proto:port[/path]
+	spec := d.Routing.HealthCheck
+	s := strings.SplitN(spec, ":", 2)
+	if len(s) != 2 {
+		return "", errors.New("Invalid health check: " + spec)
+	}
+
+	proto, port, path := strings.ToUpper(s[0]), s[1], ""
+	if i := strings.Index(port, "/"); i >= 0 {
+		port, path = port[:i], port[i:]
+	}
+
+	p, err := strconv.ParseInt(port, 10, 64)
+	if err != nil || (proto != "HTTP" && proto != "HTTPS" && proto != "TCP") ||
+		(proto == "TCP" && path != "") {
+		return "", errors.New("Invalid health check: " + spec)
+	}
+
+	// The checked endpoint is the record address:
+	if r.Type != "A" || len(r.Data) != 1 {
+		return "", errors.New("Health checks need a single A record: " + r.Name)
+	}
+
+	config := &route53.HealthCheckConfig{
+		IPAddress:        aws.String(r.Data[0]),
+		Port:             aws.Int64(p),
+		Type:             aws.String(proto),
+		RequestInterval:  aws.Int64(30),
+		FailureThreshold: aws.Int64(3),
+	}
+
+	if path != "" {
+		config.ResourcePath = aws.String(path)
+	}
+
+	// Reuse the check of a previous 'record add':
+	sum := sha1.Sum([]byte(name + " " + r.Data[0] + " " + spec))
+	prefix := fmt.Sprintf("kato-%x-", sum[:16])
+	id, err := d.findHealthCheck(prefix)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	// Send the health check request:
+	ref := prefix + strconv.FormatInt(time.Now().Unix(), 10)
+	resp, err := d.r53.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		CallerReference:   aws.String(ref),
+		HealthCheckConfig: config,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	// Log health check creation:
+	log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": *resp.HealthCheck.Id}).
+		Info("Health check created for " + r.Data[0])
+
+	return *resp.HealthCheck.Id, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: findHealthCheck
+//-----------------------------------------------------------------------------
+
+// findHealthCheck returns the ID of the health check whose caller reference
+// starts with prefix, or an empty string if there is none.
+func (d *Data) findHealthCheck(prefix string) (string, error) {
+
+	id := ""
+	err := d.r53.ListHealthChecksPages(&route53.ListHealthChecksInput{},
+		func(page *route53.ListHealthChecksOutput, last bool) bool {
+			for _, hc := range page.HealthChecks {
+				if strings.HasPrefix(*hc.CallerReference, prefix) {
+					id = *hc.Id
+					return false
+				}
+			}
+			return true
+		})
+
+	if err != nil || id == "" {
+		return "", err
+	}
+
+	log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": id}).
+		Info("Health check found")
+
+	return id, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: deleteHealthCheck
+//-----------------------------------------------------------------------------
+
+// deleteHealthCheck deletes a health check created by 'record add', the ones
+// created by other means are left alone.
+func (d *Data) deleteHealthCheck(id string) error {
+
+	// Send the get request:
+	resp, err := d.r53.GetHealthCheck(&route53.GetHealthCheckInput{
+		HealthCheckId: aws.String(id),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(*resp.HealthCheck.CallerReference, "kato-") {
+		return nil
+	}
+
+	// Send the delete request:
+	if _, err := d.r53.DeleteHealthCheck(&route53.DeleteHealthCheckInput{
+		HealthCheckId: aws.String(id),
+	}); err != nil {
+		return err
+	}
+
+	// Log health check deletion:
+	log.WithFields(log.Fields{"cmd": "r53:" + d.command, "id": id}).
+		Info("Health check deleted")
+
+	return nil
+}
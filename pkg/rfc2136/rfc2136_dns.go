@@ -0,0 +1,178 @@
+package rfc2136
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	mdns "github.com/miekg/dns"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// func: init
+//-----------------------------------------------------------------------------
+
+func init() {
+	dns.Register("rfc2136", func(apiKey string) dns.Provider {
+		return &Data{APIKey: apiKey}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// func: ShowRecords
+//-----------------------------------------------------------------------------
+
+// ShowRecords prints the records of a zone of an RFC 2136 server matching
+// the name and type filters.
+func (d *Data) ShowRecords() {
+	if err := dns.Show(d, d.Zone, d.Name, d.Type, d.Output); err != nil {
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": d.Zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: ListZones
+//-----------------------------------------------------------------------------
+
+// ListZones returns no zones, the DNS protocol has no way to enumerate the
+// zones of a server. Parent zones are found by FindParent instead.
+func (d *Data) ListZones() ([]string, error) {
+	return []string{}, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: FindParent
+//-----------------------------------------------------------------------------
+
+// FindParent returns the zone of the server holding the parent name of zone,
+// or an empty string if there is none.
+func (d *Data) FindParent(zone string) (string, error) {
+
+	if err := d.client(); err != nil {
+		return "", err
+	}
+
+	zone = strings.TrimSuffix(zone, ".")
+	i := strings.Index(zone, ".")
+	if i < 0 {
+		return "", nil
+	}
+
+	parent, err := d.zoneOf(zone[i+1:])
+	if err != nil || !strings.HasSuffix(zone, "."+parent) {
+		return "", err
+	}
+
+	return parent, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: ListRecords
+//-----------------------------------------------------------------------------
+
+// ListRecords returns all the records of a zone transferred by AXFR.
+func (d *Data) ListRecords(zone string) ([]dns.Record, error) {
+
+	// Set the current command:
+	d.command = "record:list"
+	if err := d.client(); err != nil {
+		return nil, err
+	}
+
+	// Send the transfer request:
+	m := new(mdns.Msg)
+	m.SetAxfr(mdns.Fqdn(zone))
+	if d.keyName != "" {
+		m.SetTsig(d.keyName, algorithms[d.algorithm], 300, time.Now().Unix())
+	}
+
+	t := &mdns.Transfer{
+		TsigSecret:   d.tsig,
+		DialTimeout:  time.Second * 10,
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 10,
+	}
+
+	envelopes, err := t.In(m, d.server)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the resource records into sets:
+	records, sets := []dns.Record{}, map[string]int{}
+	for e := range envelopes {
+
+		if e.Error != nil {
+			return nil, e.Error
+		}
+
+		for _, rr := range e.RR {
+
+			h := rr.Header()
+			name, rtype := dns.RelativeName(h.Name, zone), mdns.TypeToString[h.Rrtype]
+
+			// The SOA opens and closes the transfer:
+			i, ok := sets[name+":"+rtype]
+			if rtype == "SOA" && ok {
+				continue
+			}
+
+			if !ok {
+				i, sets[name+":"+rtype] = len(records), len(records)
+				records = append(records, dns.Record{Name: name, Type: rtype, TTL: int(h.Ttl)})
+			}
+
+			// TXT strings are joined back:
+			data := strings.TrimPrefix(rr.String(), h.String())
+			if txt, ok := rr.(*mdns.TXT); ok {
+				data = unquoteTXT(strings.Join(txt.Txt, ""))
+			}
+
+			records[i].Data = append(records[i].Data, data)
+		}
+	}
+
+	return records, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: NameServers
+//-----------------------------------------------------------------------------
+
+// NameServers returns the apex NS records of zone as served by the server.
+func (d *Data) NameServers(zone string) ([]string, error) {
+
+	if err := d.client(); err != nil {
+		return nil, err
+	}
+
+	// Send the NS query:
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(zone), mdns.TypeNS)
+	m.RecursionDesired = false
+
+	r, err := d.exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := []string{}
+	for _, rr := range r.Answer {
+		if n, ok := rr.(*mdns.NS); ok {
+			ns = append(ns, strings.TrimSuffix(n.Ns, "."))
+		}
+	}
+
+	return ns, nil
+}
@@ -0,0 +1,144 @@
+package rfc2136
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+	"github.com/katosys/kato/pkg/cli"
+)
+
+//-----------------------------------------------------------------------------
+// 'katoctl rfc2136' command flags definitions:
+//-----------------------------------------------------------------------------
+
+var (
+
+	// rfc2136 zone/record:
+	cmdRfc2136 = cli.App.Command("rfc2136",
+		"Manages zones and records of BIND, Knot and other RFC 2136 servers.")
+	flRfc2136APIKey = cmdRfc2136.Flag("api-key",
+		"TSIG key and primary server [algorithm:]name:secret@server[:port]").
+		Required().String()
+	cmdRfc2136Zone   = cmdRfc2136.Command("zone", "Manage RFC 2136 zones.")
+	cmdRfc2136Record = cmdRfc2136.Command("record", "Manage RFC 2136 records.")
+
+	// rfc2136 zone add:
+	cmdRfc2136ZoneAdd = cmdRfc2136Zone.Command("add",
+		"Checks that RFC 2136 zones are served and delegates them.")
+	arRfc2136ZoneAddName = cmdRfc2136ZoneAdd.Arg("fqdn",
+		"List of zones to publish.").Required().Strings()
+	flRfc2136ZoneAddParent = cmdRfc2136ZoneAdd.Flag("parent-provider",
+		"Delegate from a parent zone of this provider [ ns1 | r53 | rfc2136 ]").String()
+	flRfc2136ZoneAddParentKey = cmdRfc2136ZoneAdd.Flag("parent-api-key",
+		"API key of the parent provider.").String()
+
+	// rfc2136 zone del:
+	cmdRfc2136ZoneDel = cmdRfc2136Zone.Command("del",
+		"Purges RFC 2136 zones, the zones are left on the server.")
+	arRfc2136ZoneDelName = cmdRfc2136ZoneDel.Arg("fqdn",
+		"List of zones to delete.").Required().Strings()
+	flRfc2136ZoneDelRecursive = cmdRfc2136ZoneDel.Flag("recursive",
+		"Purge records, child zones and the parent delegation first.").Bool()
+	flRfc2136ZoneDelParent = cmdRfc2136ZoneDel.Flag("parent-provider",
+		"Remove the delegation from this provider [ ns1 | r53 | rfc2136 ]").String()
+	flRfc2136ZoneDelParentKey = cmdRfc2136ZoneDel.Flag("parent-api-key",
+		"API key of the parent provider.").String()
+
+	// rfc2136 record add:
+	cmdRfc2136RecordAdd    = cmdRfc2136Record.Command("add", "Adds records to RFC 2136 zones.")
+	flRfc2136RecordAddZone = cmdRfc2136RecordAdd.Flag("zone",
+		"DNS zone where records are added.").Required().String()
+	arRfc2136RecordAddName = cmdRfc2136RecordAdd.Arg("record",
+		"List of 'name [ttl] type data', JSON or name:type:data records.").
+		Required().Strings()
+
+	// rfc2136 record del:
+	cmdRfc2136RecordDel    = cmdRfc2136Record.Command("del", "Deletes records from RFC 2136 zones.")
+	flRfc2136RecordDelZone = cmdRfc2136RecordDel.Flag("zone",
+		"DNS zone where records are deleted.").Required().String()
+	arRfc2136RecordDelName = cmdRfc2136RecordDel.Arg("record",
+		"List of name:type records.").Required().Strings()
+
+	// rfc2136 record list:
+	cmdRfc2136RecordList = cmdRfc2136Record.Command("list",
+		"Lists records of RFC 2136 zones, exits 2 if none matches.")
+	flRfc2136RecordListZone = cmdRfc2136RecordList.Flag("zone",
+		"DNS zone where records are listed.").Required().String()
+	flRfc2136RecordListName = cmdRfc2136RecordList.Flag("name",
+		"Only records whose name matches this glob.").String()
+	flRfc2136RecordListType = cmdRfc2136RecordList.Flag("type",
+		"Only records of this type.").String()
+	flRfc2136RecordListOutput = cmdRfc2136RecordList.Flag("output",
+		"Output format [ text | json ]").
+		Default("text").Enum("text", "json")
+)
+
+//-----------------------------------------------------------------------------
+// RunCmd:
+//-----------------------------------------------------------------------------
+
+// RunCmd runs the cmd if owned by this package.
+func RunCmd(cmd string) bool {
+
+	switch cmd {
+
+	// katoctl rfc2136 zone add:
+	case cmdRfc2136ZoneAdd.FullCommand():
+		d := Data{
+			APIKey: *flRfc2136APIKey,
+			Zones:  *arRfc2136ZoneAddName,
+
+			ParentProvider: *flRfc2136ZoneAddParent,
+			ParentAPIKey:   *flRfc2136ZoneAddParentKey,
+		}
+		d.AddZones()
+
+	// katoctl rfc2136 zone del:
+	case cmdRfc2136ZoneDel.FullCommand():
+		d := Data{
+			APIKey: *flRfc2136APIKey,
+			Zones:  *arRfc2136ZoneDelName,
+
+			Recursive:      *flRfc2136ZoneDelRecursive,
+			ParentProvider: *flRfc2136ZoneDelParent,
+			ParentAPIKey:   *flRfc2136ZoneDelParentKey,
+		}
+		d.DelZones()
+
+	// katoctl rfc2136 record add:
+	case cmdRfc2136RecordAdd.FullCommand():
+		d := Data{
+			APIKey:  *flRfc2136APIKey,
+			Zone:    *flRfc2136RecordAddZone,
+			Records: *arRfc2136RecordAddName,
+		}
+		d.AddRecords()
+
+	// katoctl rfc2136 record del:
+	case cmdRfc2136RecordDel.FullCommand():
+		d := Data{
+			APIKey:  *flRfc2136APIKey,
+			Zone:    *flRfc2136RecordDelZone,
+			Records: *arRfc2136RecordDelName,
+		}
+		d.DelRecords()
+
+	// katoctl rfc2136 record list:
+	case cmdRfc2136RecordList.FullCommand():
+		d := Data{
+			APIKey: *flRfc2136APIKey,
+			Zone:   *flRfc2136RecordListZone,
+			Name:   *flRfc2136RecordListName,
+			Type:   *flRfc2136RecordListType,
+			Output: *flRfc2136RecordListOutput,
+		}
+		d.ShowRecords()
+
+	// Nothing to do:
+	default:
+		return false
+	}
+
+	return true
+}
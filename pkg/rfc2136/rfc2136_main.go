@@ -0,0 +1,508 @@
+package rfc2136
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	mdns "github.com/miekg/dns"
+
+	// Local:
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Data struct for RFC 2136 servers.
+type Data struct {
+	command   string
+	server    string            // host:port of the primary server
+	keyName   string            // FQDN of the TSIG key, if any
+	algorithm string            // TSIG algorithm
+	tsig      map[string]string // TSIG secrets by key name
+	APIKey    string            // [algorithm:]name:secret@server[:port]
+	Zones     []string
+	Zone      string
+	Records   []string
+	Name      string
+	Type      string
+	Output    string
+
+	ParentProvider string // Delegate from a parent zone of this provider
+	ParentAPIKey   string // API key of the parent provider
+	Recursive      bool   // Purge records and child zones on deletion
+}
+
+//-----------------------------------------------------------------------------
+// Package factored var statement:
+//-----------------------------------------------------------------------------
+
+var (
+
+	// DefaultTTL is the TTL of the records added without one:
+	DefaultTTL = 300
+
+	// TSIG algorithms by their name in the API key:
+	algorithms = map[string]string{
+		"hmac-md5":    mdns.HmacMD5,
+		"hmac-sha1":   mdns.HmacSHA1,
+		"hmac-sha256": mdns.HmacSHA256,
+		"hmac-sha512": mdns.HmacSHA512,
+	}
+)
+
+//-----------------------------------------------------------------------------
+// func: AddRecords
+//-----------------------------------------------------------------------------
+
+// AddRecords adds one or more records to a zone of an RFC 2136 server.
+func (d *Data) AddRecords() {
+	if err := d.CreateRecords(d.Zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": d.Zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelRecords
+//-----------------------------------------------------------------------------
+
+// DelRecords deletes one or more records from a zone of an RFC 2136 server.
+func (d *Data) DelRecords() {
+	if err := d.DeleteRecords(d.Zone, d.Records...); err != nil {
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": d.Zone}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: AddZones
+//-----------------------------------------------------------------------------
+
+// AddZones checks that one or more zones are served and delegates them from
+// their parent zone. The zones without a parent in the server are delegated
+// from the parent provider, if any.
+func (d *Data) AddZones() {
+
+	if err := d.CreateZones(d.Zones...); err != nil {
+		log.WithField("cmd", "rfc2136:"+d.command).Fatal(err)
+	}
+
+	if err := dns.DelegateAcross(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": d.ParentProvider}).
+			Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: DelZones
+//-----------------------------------------------------------------------------
+
+// DelZones purges the records and parent delegations of one or more zones
+// when recursive. Zones themselves are never removed from the server.
+func (d *Data) DelZones() {
+
+	if d.Recursive {
+		d.command = "zone:del"
+		if err := dns.Purge(d, d.ParentProvider, d.ParentAPIKey, d.Zones...); err != nil {
+			log.WithField("cmd", "rfc2136:"+d.command).Fatal(err)
+		}
+		return
+	}
+
+	if err := d.DeleteZones(d.Zones...); err != nil {
+		log.WithField("cmd", "rfc2136:"+d.command).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: CreateRecords
+//-----------------------------------------------------------------------------
+
+// CreateRecords replaces the record sets of a zone in a single dynamic
+// update. See dns.ParseRecords for the accepted record specs.
+func (d *Data) CreateRecords(zone string, records ...string) error {
+
+	// Set the current command:
+	d.command = "record:add"
+	d.Zone = strings.TrimSuffix(zone, ".")
+	if err := d.client(); err != nil {
+		return err
+	}
+
+	// Parse and validate all the records first:
+	sets, err := dns.ParseRecords(records...)
+	if err != nil {
+		return err
+	}
+
+	// Every set is deleted and added back:
+	del, add := []mdns.RR{}, []mdns.RR{}
+	for _, r := range sets {
+		rrs, err := d.resourceRecords(r)
+		if err != nil {
+			return err
+		}
+		del, add = append(del, rrs[0]), append(add, rrs...)
+	}
+
+	// Send the update request (deletions go first):
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(d.Zone))
+	m.RemoveRRset(del)
+	m.Insert(add)
+
+	if _, err := d.exchange(m); err != nil {
+		return err
+	}
+
+	// Log record creation:
+	for _, r := range sets {
+		name := strings.TrimSuffix(d.fqdn(r.Name), ".")
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": name}).
+			Info("New DNS record created/updated")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: DeleteRecords
+//-----------------------------------------------------------------------------
+
+// DeleteRecords deletes name:type records from a zone in a single dynamic
+// update. Missing records are not an error.
+func (d *Data) DeleteRecords(zone string, records ...string) error {
+
+	// Set the current command:
+	d.command = "record:del"
+	d.Zone = strings.TrimSuffix(zone, ".")
+	if err := d.client(); err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Split into name:type
+	del := []mdns.RR{}
+	for _, record := range records {
+
+		s := strings.Split(record, ":")
+		if len(s) != 2 {
+			return errors.New("Invalid record: " + record)
+		}
+
+		t, ok := mdns.StringToType[strings.ToUpper(s[1])]
+		if !ok {
+			return errors.New("Unsupported record type: " + s[1])
+		}
+
+		del = append(del, &mdns.ANY{Hdr: mdns.RR_Header{
+			Name: d.fqdn(s[0]), Rrtype: t, Class: mdns.ClassINET}})
+	}
+
+	// Send the update request:
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(d.Zone))
+	m.RemoveRRset(del)
+
+	if _, err := d.exchange(m); err != nil {
+		return err
+	}
+
+	// Log record deletion:
+	for _, rr := range del {
+		name := strings.TrimSuffix(rr.Header().Name, ".")
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": name}).
+			Info("DNS record deleted")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: CreateZones
+//-----------------------------------------------------------------------------
+
+// CreateZones checks that the server is authoritative for the zones and
+// delegates them from their parent zone, if any. RFC 2136 can't create zones
+// so they must be configured on the server beforehand.
+func (d *Data) CreateZones(zones ...string) error {
+
+	// Set the current command:
+	d.command = "zone:add"
+	if err := d.client(); err != nil {
+		return err
+	}
+
+	// For each requested zone:
+	for _, zone := range zones {
+
+		// The zone must be served:
+		zone = strings.TrimSuffix(zone, ".")
+		apex, err := d.zoneOf(zone)
+		if err != nil {
+			return err
+		}
+
+		if apex != zone {
+			return errors.New("Zone not served by " + d.server + ": " + zone)
+		}
+
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": zone}).
+			Info("DNS zone served by " + d.server)
+
+		// Delegate from the parent zone, if any:
+		p := *d
+		pZone, err := dns.Delegate(&p, &p, zone)
+		if err != nil {
+			return err
+		}
+
+		if pZone != "" {
+			log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": zone}).
+				Info("DNS zone delegated from " + pZone)
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: DeleteZones
+//-----------------------------------------------------------------------------
+
+// DeleteZones leaves the zones on the server, RFC 2136 can't remove them.
+// Use 'zone del --recursive' to purge their records.
+func (d *Data) DeleteZones(zones ...string) error {
+
+	// Set the current command:
+	d.command = "zone:del"
+	if err := d.client(); err != nil {
+		return err
+	}
+
+	for _, zone := range zones {
+		log.WithFields(log.Fields{"cmd": "rfc2136:" + d.command, "id": zone}).
+			Warning("Zones can't be deleted by RFC 2136, left on " + d.server)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: client
+//-----------------------------------------------------------------------------
+
+// client splits the [algorithm:]name:secret@server[:port] API key once. The
+// TSIG key is optional and the algorithm defaults to hmac-sha256.
+func (d *Data) client() error {
+
+	if d.tsig != nil {
+		return nil
+	}
+
+	// Split into key@server
+	key, server := "", d.APIKey
+	if i := strings.LastIndex(server, "@"); i >= 0 {
+		key, server = server[:i], server[i+1:]
+	}
+
+	if server == "" {
+		return errors.New("Missing server, the API key is [algorithm:]name:secret@server[:port]")
+	}
+
+	// Port 53 by default:
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	// Split into [algorithm:]name:secret
+	d.algorithm = "hmac-sha256"
+	if key != "" {
+		s := strings.Split(key, ":")
+		if len(s) == 3 {
+			d.algorithm, s = s[0], s[1:]
+		}
+
+		if len(s) != 2 || s[0] == "" || s[1] == "" {
+			return errors.New("Invalid TSIG key, expected [algorithm:]name:secret")
+		}
+
+		if _, ok := algorithms[d.algorithm]; !ok {
+			return errors.New("Unsupported TSIG algorithm: " + d.algorithm)
+		}
+
+		d.keyName = mdns.Fqdn(s[0])
+		d.tsig = map[string]string{d.keyName: s[1]}
+	}
+
+	if d.tsig == nil {
+		d.tsig = map[string]string{}
+	}
+
+	d.server = server
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: exchange
+//-----------------------------------------------------------------------------
+
+// exchange signs m, if there is a TSIG key, and sends it over TCP. Answers
+// other than NOERROR and NXDOMAIN are returned along with an error.
+func (d *Data) exchange(m *mdns.Msg) (*mdns.Msg, error) {
+
+	if d.keyName != "" {
+		m.SetTsig(d.keyName, algorithms[d.algorithm], 300, time.Now().Unix())
+	}
+
+	c := &mdns.Client{
+		Net:          "tcp",
+		TsigSecret:   d.tsig,
+		DialTimeout:  time.Second * 10,
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 10,
+	}
+
+	r, _, err := c.Exchange(m, d.server)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Rcode != mdns.RcodeSuccess && r.Rcode != mdns.RcodeNameError {
+		return r, fmt.Errorf("%s answered %s", d.server, mdns.RcodeToString[r.Rcode])
+	}
+
+	return r, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: zoneOf
+//-----------------------------------------------------------------------------
+
+// zoneOf returns the zone of the server holding name, or an empty string if
+// the server is not authoritative for it.
+func (d *Data) zoneOf(name string) (string, error) {
+
+	// Send the SOA query:
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(name), mdns.TypeSOA)
+	m.RecursionDesired = false
+
+	r, err := d.exchange(m)
+	if err != nil {
+		if r != nil && r.Rcode == mdns.RcodeRefused {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if !r.Authoritative {
+		return "", nil
+	}
+
+	// The apex answers its SOA, other names get it as authority:
+	for _, rr := range append(r.Answer, r.Ns...) {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			return strings.TrimSuffix(soa.Hdr.Name, "."), nil
+		}
+	}
+
+	return "", nil
+}
+
+//-----------------------------------------------------------------------------
+// func: fqdn
+//-----------------------------------------------------------------------------
+
+// fqdn returns the dot ended name of a record of d.Zone, '@' is the apex.
+func (d *Data) fqdn(name string) string {
+	if name == "@" {
+		return mdns.Fqdn(d.Zone)
+	}
+	return mdns.Fqdn(name + "." + d.Zone)
+}
+
+//-----------------------------------------------------------------------------
+// func: resourceRecords
+//-----------------------------------------------------------------------------
+
+// resourceRecords returns one resource record per data of the record set.
+func (d *Data) resourceRecords(r dns.Record) ([]mdns.RR, error) {
+
+	ttl := r.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	rrs := []mdns.RR{}
+	for _, data := range r.Data {
+
+		if r.Type == "TXT" {
+			data = quoteTXT(data)
+		}
+
+		rr, err := mdns.NewRR(fmt.Sprintf("%s %d IN %s %s", d.fqdn(r.Name), ttl, r.Type, data))
+		if err != nil {
+			return nil, err
+		}
+
+		rrs = append(rrs, rr)
+	}
+
+	if len(rrs) == 0 {
+		return nil, errors.New("Record without data: " + r.Name)
+	}
+
+	return rrs, nil
+}
+
+//-----------------------------------------------------------------------------
+// func: quoteTXT
+//-----------------------------------------------------------------------------
+
+// quoteTXT quotes a TXT value as a single character string.
+func quoteTXT(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+//-----------------------------------------------------------------------------
+// func: unquoteTXT
+//-----------------------------------------------------------------------------
+
+// unquoteTXT reverts the \X and \DDD escapes of a TXT character string.
+func unquoteTXT(s string) string {
+
+	b := []byte{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) {
+			i, c = i+1, s[i+1]
+			if i+3 <= len(s) {
+				if n, err := strconv.ParseUint(s[i:i+3], 10, 8); err == nil {
+					i, c = i+2, byte(n)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,360 @@
+package rfc2136
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+
+	"github.com/katosys/kato/pkg/dns"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Authoritative server stand-in answering queries, AXFR and dynamic updates
+// for the zones it holds. The first record of every zone is its SOA.
+type authServer struct {
+	sync.Mutex
+	zones map[string][]mdns.RR
+}
+
+// TSIG key shared with the stand-in, the secret is base64 for 'secret':
+const (
+	keyName   = "kato."
+	keySecret = "c2VjcmV0"
+)
+
+//-----------------------------------------------------------------------------
+// func: zoneOf
+//-----------------------------------------------------------------------------
+
+func (s *authServer) zoneOf(name string) string {
+	zone := ""
+	for z := range s.zones {
+		if mdns.IsSubDomain(z, name) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	return zone
+}
+
+//-----------------------------------------------------------------------------
+// func: ServeDNS
+//-----------------------------------------------------------------------------
+
+func (s *authServer) ServeDNS(w mdns.ResponseWriter, r *mdns.Msg) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	m := new(mdns.Msg)
+	m.SetReply(r)
+
+	// Signed requests get signed answers:
+	if t := r.IsTsig(); t != nil {
+		if w.TsigStatus() != nil {
+			m.Rcode = mdns.RcodeNotAuth
+			_ = w.WriteMsg(m)
+			return
+		}
+		m.SetTsig(t.Hdr.Name, mdns.HmacSHA256, 300, int64(t.TimeSigned))
+	}
+
+	zone := s.zoneOf(r.Question[0].Name)
+	if zone == "" {
+		m.Rcode = mdns.RcodeRefused
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	m.Authoritative = true
+
+	switch {
+
+	case r.Opcode == mdns.OpcodeUpdate:
+		s.update(zone, r.Ns)
+
+	case r.Question[0].Qtype == mdns.TypeAXFR:
+		ch := make(chan *mdns.Envelope, 1)
+		ch <- &mdns.Envelope{RR: append(append([]mdns.RR{}, s.zones[zone]...), s.zones[zone][0])}
+		close(ch)
+		t := &mdns.Transfer{TsigSecret: map[string]string{keyName: keySecret}}
+		_ = t.Out(w, r, ch)
+		w.Hijack()
+		return
+
+	default:
+		s.query(zone, r.Question[0], m)
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+//-----------------------------------------------------------------------------
+// func: update
+//-----------------------------------------------------------------------------
+
+// update applies the RRset deletions (class ANY) and the additions, the SOA
+// is never deleted.
+func (s *authServer) update(zone string, rrs []mdns.RR) {
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Class != mdns.ClassANY {
+			s.zones[zone] = append(s.zones[zone], rr)
+			continue
+		}
+		keep := []mdns.RR{}
+		for _, x := range s.zones[zone] {
+			if x.Header().Rrtype == mdns.TypeSOA || x.Header().Rrtype != h.Rrtype ||
+				!strings.EqualFold(x.Header().Name, h.Name) {
+				keep = append(keep, x)
+			}
+		}
+		s.zones[zone] = keep
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: query
+//-----------------------------------------------------------------------------
+
+func (s *authServer) query(zone string, q mdns.Question, m *mdns.Msg) {
+
+	exists := false
+	for _, x := range s.zones[zone] {
+		if strings.EqualFold(x.Header().Name, q.Name) {
+			exists = true
+			if x.Header().Rrtype == q.Qtype {
+				m.Answer = append(m.Answer, x)
+			}
+		}
+	}
+
+	if len(m.Answer) == 0 {
+		m.Ns = []mdns.RR{s.zones[zone][0]}
+		if !exists {
+			m.Rcode = mdns.RcodeNameError
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: newAuthServer
+//-----------------------------------------------------------------------------
+
+// newAuthServer serves example.com and its child zone sub.example.com on a
+// local TCP port and returns the address along with a shutdown function.
+func newAuthServer(t *testing.T) (*authServer, string, func()) {
+
+	rr := func(s string) mdns.RR {
+		r, err := mdns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	s := &authServer{zones: map[string][]mdns.RR{
+		"example.com.": {
+			rr("example.com. 300 IN SOA ns1.example.com. h. 1 2 3 4 5"),
+			rr("example.com. 300 IN NS ns1.example.com."),
+		},
+		"sub.example.com.": {
+			rr("sub.example.com. 300 IN SOA ns1.example.com. h. 1 2 3 4 5"),
+			rr("sub.example.com. 300 IN NS ns1.example.com."),
+			rr("sub.example.com. 300 IN NS ns2.example.com."),
+		},
+	}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &mdns.Server{
+		Listener:   l,
+		Handler:    s,
+		TsigSecret: map[string]string{keyName: keySecret},
+		MsgAcceptFunc: func(mdns.Header) mdns.MsgAcceptAction {
+			return mdns.MsgAccept // Dynamic updates included
+		},
+	}
+
+	go func() { _ = server.ActivateAndServe() }()
+	return s, l.Addr().String(), func() { _ = server.Shutdown() }
+}
+
+//-----------------------------------------------------------------------------
+// func: find
+//-----------------------------------------------------------------------------
+
+func find(records []dns.Record, name, rtype string) *dns.Record {
+	for i := range records {
+		if records[i].Name == name && records[i].Type == rtype {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: TestRecords
+//-----------------------------------------------------------------------------
+
+func TestRecords(t *testing.T) {
+
+	_, addr, shutdown := newAuthServer(t)
+	defer shutdown()
+
+	p, err := dns.New("rfc2136", "hmac-sha256:kato:"+keySecret+"@"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create and upsert:
+	if err := p.CreateRecords("sub.example.com.", "www:A:10.0.0.1,10.0.0.2",
+		`txt 60 TXT "hello \"world\""`, "@ MX 10 mail.example.com."); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.CreateRecords("sub.example.com", "www:A:10.0.0.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := p.ListRecords("sub.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, rtype string
+		ttl         int
+		data        []string
+	}{
+		{"www", "A", DefaultTTL, []string{"10.0.0.3"}},
+		{"txt", "TXT", 60, []string{`hello \"world\"`}},
+		{"@", "MX", DefaultTTL, []string{"10 mail.example.com."}},
+		{"@", "NS", 300, []string{"ns1.example.com.", "ns2.example.com."}},
+	}
+
+	for _, tt := range tests {
+		r := find(records, tt.name, tt.rtype)
+		if r == nil {
+			t.Errorf("%s %s: missing in %+v", tt.name, tt.rtype, records)
+			continue
+		}
+		if r.TTL != tt.ttl || strings.Join(r.Data, ",") != strings.Join(tt.data, ",") {
+			t.Errorf("%s %s: got %+v", tt.name, tt.rtype, *r)
+		}
+	}
+
+	// Delete, missing records are not an error:
+	if err := p.DeleteRecords("sub.example.com", "www:a", "nothere:A"); err != nil {
+		t.Fatal(err)
+	}
+
+	if records, err = p.ListRecords("sub.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if find(records, "www", "A") != nil {
+		t.Errorf("www A not deleted: %+v", records)
+	}
+
+	// Invalid specs never reach the server:
+	if err := p.CreateRecords("sub.example.com", "www AAAA 10.0.0.1"); err == nil {
+		t.Error("expected an error for an invalid record")
+	}
+
+	// Wrong TSIG secret:
+	bad, _ := dns.New("rfc2136", "kato:d3Jvbmc=@"+addr)
+	if err := bad.CreateRecords("sub.example.com", "x:A:10.0.0.1"); err == nil {
+		t.Error("expected an error for a wrong TSIG secret")
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestZones
+//-----------------------------------------------------------------------------
+
+func TestZones(t *testing.T) {
+
+	_, addr, shutdown := newAuthServer(t)
+	defer shutdown()
+
+	p, err := dns.New("rfc2136", "kato:"+keySecret+"@"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zones must be served beforehand:
+	if err := p.CreateZones("nope.org"); err == nil {
+		t.Error("expected an error for a zone not served")
+	}
+
+	// The child zone is delegated from its parent:
+	if err := p.CreateZones("sub.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := p.ListRecords("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := find(parent, "sub", "NS"); r == nil || len(r.Data) != 2 {
+		t.Fatalf("missing delegation in %+v", parent)
+	}
+
+	// Purge the records and the delegation, the zone stays:
+	if err := p.CreateRecords("sub.example.com", "www:A:10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := dns.PurgeZones(p, nil, "sub.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summary) != 1 || summary[0].Records != 1 || summary[0].Parent != "example.com" {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	if parent, err = p.ListRecords("example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if find(parent, "sub", "NS") != nil {
+		t.Errorf("delegation not removed: %+v", parent)
+	}
+
+	sub, err := p.ListRecords("sub.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if find(sub, "www", "A") != nil || find(sub, "@", "NS") == nil {
+		t.Errorf("unexpected records left: %+v", sub)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: TestQuoteTXT
+//-----------------------------------------------------------------------------
+
+func TestQuoteTXT(t *testing.T) {
+	for _, v := range []string{`hello "world"`, `a\b`, "v=spf1 -all", "x\x01y", ""} {
+		r, err := mdns.NewRR("a. 60 IN TXT " + quoteTXT(v))
+		if err != nil {
+			t.Errorf("%q: %s", v, err)
+			continue
+		}
+		if got := unquoteTXT(strings.Join(r.(*mdns.TXT).Txt, "")); got != v {
+			t.Errorf("got %q, want %q", got, v)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/ec2"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Portable state bundle:
+type bundle struct {
+	Kind      string          `json:"Kind"`
+	ClusterID string          `json:"ClusterID"`
+	Exported  string          `json:"Exported"`
+	State     json.RawMessage `json:"State"`
+}
+
+const bundleKind = "kato-state-bundle"
+
+//-----------------------------------------------------------------------------
+// func: Export
+//-----------------------------------------------------------------------------
+
+// Export writes the state to a bundle file to be handed to another operator.
+// The bundle is sealed with the state key, if any.
+func (d *Data) Export() {
+
+	// Set current command:
+	d.command = "export"
+
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if raw, _, _, err = ec2.MigrateState(raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	// Forge the bundle:
+	data, err := json.MarshalIndent(bundle{
+		Kind:      bundleKind,
+		ClusterID: d.ClusterID,
+		Exported:  time.Now().UTC().Format(time.RFC3339),
+		State:     raw,
+	}, "", "  ")
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	sealed, err := kato.Seal(data)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if bytes.Equal(sealed, data) {
+		log.WithField("cmd", "state:"+d.command).
+			Warning("No state key set, the bundle holds the secrets in plain text")
+	}
+
+	// Write the bundle:
+	if err := ioutil.WriteFile(d.File, sealed, 0600); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.File}).
+		Info("State exported")
+}
+
+//-----------------------------------------------------------------------------
+// func: Import
+//-----------------------------------------------------------------------------
+
+// Import writes the state found in a bundle file. Existing states are only
+// overwritten if forced.
+func (d *Data) Import() {
+
+	// Set current command:
+	d.command = "import"
+
+	// Read and open the bundle:
+	data, err := ioutil.ReadFile(d.File)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if data, err = kato.Unseal(data); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	b := bundle{}
+	if err := json.Unmarshal(data, &b); err != nil || b.Kind != bundleKind {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.File}).
+			Fatal("Not a state bundle")
+	}
+
+	d.ClusterID = b.ClusterID
+
+	// Upgrade and validate the state:
+	raw, _, _, err := ec2.MigrateState(b.State)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if raw, err = validate(state); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Don't overwrite by accident:
+	if _, err := kato.ReadState(d.ClusterID); err == nil && !d.Force {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+			Fatal("State already exists, use --force to overwrite it")
+	} else if err != nil && !os.IsNotExist(err) && !d.Force {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if err := kato.WriteState(d.ClusterID, raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+		Info("State imported, exported on " + b.Exported)
+}
@@ -0,0 +1,284 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/ec2"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// func: Show
+//-----------------------------------------------------------------------------
+
+// Show pretty-prints the state with its secrets redacted.
+func (d *Data) Show() {
+
+	// Set current command:
+	d.command = "show"
+
+	state := d.readState()
+	for _, k := range kato.SecretFields {
+		if v, ok := state[k].(string); ok && v != "" {
+			state[k] = redact(k, v)
+		}
+	}
+
+	d.print(state)
+}
+
+//-----------------------------------------------------------------------------
+// func: Get
+//-----------------------------------------------------------------------------
+
+// Get prints the value of a single field, strings are printed unquoted.
+func (d *Data) Get() {
+
+	// Set current command:
+	d.command = "get"
+
+	value, err := lookup(d.readState(), d.Path)
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.Path}).Fatal(err)
+	}
+
+	if s, ok := value.(string); ok {
+		fmt.Println(s)
+		return
+	}
+
+	d.print(value)
+}
+
+//-----------------------------------------------------------------------------
+// func: Set
+//-----------------------------------------------------------------------------
+
+// Set changes the value of a single field. The value is read as JSON first
+// and as a plain string otherwise, the result must fit the State schema.
+func (d *Data) Set() {
+
+	// Set current command:
+	d.command = "set"
+
+	if d.Path == "Version" {
+		log.WithField("cmd", "state:"+d.command).
+			Fatal("The version is managed by 'katoctl state migrate'")
+	}
+
+	// Keep other operators out:
+	defer d.lockState()()
+
+	// Try JSON first, a plain string last:
+	var candidates []interface{}
+	var value interface{}
+	if err := json.Unmarshal([]byte(d.Value), &value); err == nil {
+		candidates = append(candidates, value)
+	}
+	candidates = append(candidates, d.Value)
+
+	var raw []byte
+	var err error
+	for _, v := range candidates {
+		state := d.readState()
+		if err = assign(state, d.Path, v); err != nil {
+			continue
+		}
+		if raw, err = validate(state); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.Path}).Fatal(err)
+	}
+
+	// Write the state back:
+	if err := kato.WriteState(d.ClusterID, raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.Path}).
+		Info("State field updated")
+}
+
+//-----------------------------------------------------------------------------
+// func: readState
+//-----------------------------------------------------------------------------
+
+// readState returns the migrated state as a generic JSON document.
+func (d *Data) readState() map[string]interface{} {
+
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if raw, _, _, err = ec2.MigrateState(raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	return state
+}
+
+//-----------------------------------------------------------------------------
+// func: print
+//-----------------------------------------------------------------------------
+
+func (d *Data) print(v interface{}) {
+
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if _, err := fmt.Fprintln(os.Stdout, string(out)); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: validate
+//-----------------------------------------------------------------------------
+
+// validate checks the state against the State schema and returns it in its
+// canonical form. Unknown fields and mismatching types are rejected.
+func validate(state map[string]interface{}) ([]byte, error) {
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	// Type check:
+	s := ec2.State{}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+
+	// Unknown fields check:
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]json.RawMessage{}
+	if err := json.Unmarshal(out, &known); err != nil {
+		return nil, err
+	}
+
+	for k := range state {
+		if _, ok := known[k]; !ok {
+			return nil, errors.New("Unknown state field " + k)
+		}
+	}
+
+	return out, nil
+}
+
+//-----------------------------------------------------------------------------
+// Path helpers:
+//-----------------------------------------------------------------------------
+
+// lookup returns the value at the dot separated path (e.g. SecGrps.quorum
+// or StubZones.0).
+func lookup(doc interface{}, path string) (interface{}, error) {
+
+	for _, k := range strings.Split(path, ".") {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[k]
+			if !ok {
+				return nil, errors.New("No such field " + path)
+			}
+			doc = v
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, errors.New("No such item " + path)
+			}
+			doc = node[i]
+		default:
+			return nil, errors.New("No such field " + path)
+		}
+	}
+
+	return doc, nil
+}
+
+// assign sets the value at the dot separated path, missing map keys are
+// created and a list index equal to the length appends.
+func assign(state map[string]interface{}, path string, value interface{}) error {
+
+	keys := strings.Split(path, ".")
+	parent, last := keys[:len(keys)-1], keys[len(keys)-1]
+
+	// Walk to the parent, creating maps on the way:
+	var doc interface{} = state
+	for _, k := range parent {
+		if node, ok := doc.(map[string]interface{}); ok && node[k] == nil {
+			node[k] = map[string]interface{}{}
+		}
+		next, err := lookup(doc, k)
+		if err != nil {
+			return err
+		}
+		doc = next
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		i, err := strconv.Atoi(last)
+		if err != nil || i < 0 || i > len(node) {
+			return errors.New("No such item " + path)
+		}
+		if i == len(node) {
+			return assign(state, strings.Join(parent, "."), append(node, value))
+		}
+		node[i] = value
+	default:
+		return errors.New("No such field " + path)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: redact
+//-----------------------------------------------------------------------------
+
+// redact hides a secret, only the password of the SMTP URL is hidden.
+func redact(field, value string) string {
+
+	if field == "SMTPURL" {
+		r := regexp.MustCompile("^(smtp://.+:)(.+)(@.+:\\d+)$")
+		if r.MatchString(value) {
+			return r.ReplaceAllString(value, "${1}REDACTED${3}")
+		}
+	}
+
+	return "REDACTED"
+}
@@ -0,0 +1,239 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+	"github.com/katosys/kato/pkg/cli"
+)
+
+//-----------------------------------------------------------------------------
+// 'katoctl state' command flags definitions:
+//-----------------------------------------------------------------------------
+
+var (
+
+	//--------------------------
+	// state: top level command
+	//--------------------------
+
+	cmdState = cli.App.Command("state", "Manage the cluster state files.")
+
+	//--------------------
+	// state show: nested
+	//--------------------
+
+	cmdStateShow = cmdState.Command("show",
+		"Pretty-print a state with its secrets redacted.")
+
+	flStateShowClusterID = cmdStateShow.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_SHOW_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_SHOW_CLUSTER_ID").
+		String()
+
+	//-------------------
+	// state get: nested
+	//-------------------
+
+	cmdStateGet = cmdState.Command("get",
+		"Print a single state field.")
+
+	flStateGetClusterID = cmdStateGet.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_GET_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_GET_CLUSTER_ID").
+		String()
+
+	arStateGetPath = cmdStateGet.Arg("path",
+		"Dot separated field path (e.g. SecGrps.quorum).").
+		Required().String()
+
+	//-------------------
+	// state set: nested
+	//-------------------
+
+	cmdStateSet = cmdState.Command("set",
+		"Change a single state field.")
+
+	flStateSetClusterID = cmdStateSet.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_SET_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_SET_CLUSTER_ID").
+		String()
+
+	arStateSetPath = cmdStateSet.Arg("path",
+		"Dot separated field path (e.g. SecGrps.quorum).").
+		Required().String()
+
+	arStateSetValue = cmdStateSet.Arg("value",
+		"New value, JSON or plain string.").
+		Required().String()
+
+	//----------------------
+	// state export: nested
+	//----------------------
+
+	cmdStateExport = cmdState.Command("export",
+		"Write a state to a portable bundle file.")
+
+	flStateExportClusterID = cmdStateExport.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_EXPORT_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_EXPORT_CLUSTER_ID").
+		String()
+
+	flStateExportFile = cmdStateExport.Flag("file",
+		"Bundle file to write.").
+		Short('f').Required().PlaceHolder("KATO_STATE_EXPORT_FILE").
+		OverrideDefaultFromEnvar("KATO_STATE_EXPORT_FILE").
+		String()
+
+	//----------------------
+	// state import: nested
+	//----------------------
+
+	cmdStateImport = cmdState.Command("import",
+		"Write the state found in a bundle file.")
+
+	flStateImportFile = cmdStateImport.Flag("file",
+		"Bundle file to read.").
+		Short('f').Required().PlaceHolder("KATO_STATE_IMPORT_FILE").
+		OverrideDefaultFromEnvar("KATO_STATE_IMPORT_FILE").
+		ExistingFile()
+
+	flStateImportForce = cmdStateImport.Flag("force",
+		"Overwrite an existing state.").
+		Default("false").OverrideDefaultFromEnvar("KATO_STATE_IMPORT_FORCE").
+		Bool()
+
+	//-----------------------
+	// state migrate: nested
+	//-----------------------
+
+	cmdStateMigrate = cmdState.Command("migrate",
+		"Upgrade a state file to the current schema version.")
+
+	flStateMigrateClusterID = cmdStateMigrate.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_MIGRATE_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_MIGRATE_CLUSTER_ID").
+		String()
+
+	flStateMigrateDryRun = cmdStateMigrate.Flag("dry-run",
+		"List the migrations without writing the state.").
+		Default("false").OverrideDefaultFromEnvar("KATO_STATE_MIGRATE_DRY_RUN").
+		Bool()
+
+	//-----------------------
+	// state secrets: nested
+	//-----------------------
+
+	cmdStateSecrets = cmdState.Command("secrets",
+		"Print the secrets env file referenced by 'udata --secrets-url'.")
+
+	flStateSecretsClusterID = cmdStateSecrets.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_SECRETS_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_SECRETS_CLUSTER_ID").
+		String()
+
+	//----------------------
+	// state unlock: nested
+	//----------------------
+
+	cmdStateUnlock = cmdState.Command("unlock",
+		"Release a state lock left behind by a dead process.")
+
+	flStateUnlockClusterID = cmdStateUnlock.Flag("cluster-id",
+		"Cluster ID of the state file.").
+		Required().PlaceHolder("KATO_STATE_UNLOCK_CLUSTER_ID").
+		OverrideDefaultFromEnvar("KATO_STATE_UNLOCK_CLUSTER_ID").
+		String()
+
+	flStateUnlockForce = cmdStateUnlock.Flag("force",
+		"Release the lock whoever holds it.").
+		Default("false").OverrideDefaultFromEnvar("KATO_STATE_UNLOCK_FORCE").
+		Bool()
+)
+
+//-----------------------------------------------------------------------------
+// RunCmd:
+//-----------------------------------------------------------------------------
+
+// RunCmd runs the cmd if owned by this package.
+func RunCmd(cmd string) bool {
+
+	switch cmd {
+
+	// katoctl state show
+	case cmdStateShow.FullCommand():
+		d := Data{
+			ClusterID: *flStateShowClusterID,
+		}
+		d.Show()
+
+	// katoctl state get
+	case cmdStateGet.FullCommand():
+		d := Data{
+			ClusterID: *flStateGetClusterID,
+			Path:      *arStateGetPath,
+		}
+		d.Get()
+
+	// katoctl state set
+	case cmdStateSet.FullCommand():
+		d := Data{
+			ClusterID: *flStateSetClusterID,
+			Path:      *arStateSetPath,
+			Value:     *arStateSetValue,
+		}
+		d.Set()
+
+	// katoctl state export
+	case cmdStateExport.FullCommand():
+		d := Data{
+			ClusterID: *flStateExportClusterID,
+			File:      *flStateExportFile,
+		}
+		d.Export()
+
+	// katoctl state import
+	case cmdStateImport.FullCommand():
+		d := Data{
+			File:  *flStateImportFile,
+			Force: *flStateImportForce,
+		}
+		d.Import()
+
+	// katoctl state migrate
+	case cmdStateMigrate.FullCommand():
+		d := Data{
+			ClusterID: *flStateMigrateClusterID,
+			DryRun:    *flStateMigrateDryRun,
+		}
+		d.Migrate()
+
+	// katoctl state secrets
+	case cmdStateSecrets.FullCommand():
+		d := Data{
+			ClusterID: *flStateSecretsClusterID,
+		}
+		d.Secrets()
+
+	// katoctl state unlock
+	case cmdStateUnlock.FullCommand():
+		d := Data{
+			ClusterID: *flStateUnlockClusterID,
+			Force:     *flStateUnlockForce,
+		}
+		d.Unlock()
+
+	// Nothing to do:
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,184 @@
+package state
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+
+	// Local:
+	"github.com/katosys/kato/pkg/ec2"
+	"github.com/katosys/kato/pkg/kato"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Data struct for the state commands.
+type Data struct {
+	command   string
+	ClusterID string
+	DryRun    bool   // List the changes without writing them
+	Path      string // Dot separated path of a State field
+	Value     string // New value of the field
+	File      string // Bundle file
+	Force     bool   // Overwrite an existing state or break a lock
+}
+
+//-----------------------------------------------------------------------------
+// func: lockState
+//-----------------------------------------------------------------------------
+
+// lockState takes the state lock or exits and returns the release function.
+func (d *Data) lockState() func() {
+
+	unlock, err := kato.LockState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			if err := unlock(); err != nil {
+				log.WithField("cmd", "state:"+d.command).Warning(err)
+			}
+		}
+	}
+
+	log.RegisterExitHandler(release)
+	return release
+}
+
+//-----------------------------------------------------------------------------
+// func: Migrate
+//-----------------------------------------------------------------------------
+
+// Migrate upgrades the state file to the current schema version. The state
+// is locked while it is rewritten.
+func (d *Data) Migrate() {
+
+	// Set current command:
+	d.command = "migrate"
+
+	// Keep other operators out:
+	if !d.DryRun {
+		defer d.lockState()()
+	}
+
+	// Read and upgrade the state:
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	raw, from, applied, err := ec2.MigrateState(raw)
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).Fatal(err)
+	}
+
+	if len(applied) == 0 {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+			Info("State already at version " + strconv.Itoa(from))
+		return
+	}
+
+	for _, m := range applied {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).Info(m)
+	}
+
+	if d.DryRun {
+		return
+	}
+
+	// Write the upgraded state back:
+	if err := kato.WriteState(d.ClusterID, raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+		Info("State upgraded to version " + strconv.Itoa(ec2.StateVersion))
+}
+
+//-----------------------------------------------------------------------------
+// func: Secrets
+//-----------------------------------------------------------------------------
+
+// Secrets prints the secrets of the state as an env file to be served at the
+// URL given to 'udata --secrets-url'.
+func (d *Data) Secrets() {
+
+	// Set current command:
+	d.command = "secrets"
+
+	// Read and decode the state:
+	raw, err := kato.ReadState(d.ClusterID)
+	if err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	if raw, _, _, err = ec2.MigrateState(raw); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	s := ec2.State{}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		log.WithField("cmd", "state:"+d.command).Fatal(err)
+	}
+
+	// The SMTP password:
+	pass := ""
+	if sub := regexp.MustCompile("^smtp://(.+):(.+)@(.+):(\\d+)$").
+		FindStringSubmatch(s.SMTPURL); sub != nil {
+		pass = sub[2]
+	}
+
+	fmt.Printf("KATO_DNS_API_KEY=%s\nKATO_SMTP_PASS=%s\nKATO_SLACK_WEBHOOK=%s\n",
+		envQuote(s.DNSApiKey), envQuote(pass), envQuote(s.SlackWebhook))
+}
+
+//-----------------------------------------------------------------------------
+// func: envQuote
+//-----------------------------------------------------------------------------
+
+// envQuote double quotes a value of a systemd EnvironmentFile, which unescapes
+// backslashes, double quotes, dollars and backticks like a shell would.
+func envQuote(value string) string {
+	return `"` + regexp.MustCompile("([\\\\\"$`])").ReplaceAllString(value, "\\$1") + `"`
+}
+
+//-----------------------------------------------------------------------------
+// func: Unlock
+//-----------------------------------------------------------------------------
+
+// Unlock releases the state lock whoever holds it. The holder can't be told
+// alive from dead, so the operator must say so with --force.
+func (d *Data) Unlock() {
+
+	// Set current command:
+	d.command = "unlock"
+
+	if !d.Force {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+			Fatal("Make sure the holder is gone and use --force")
+	}
+
+	holder, err := kato.ForceUnlockState(d.ClusterID)
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).Fatal(err)
+	}
+
+	log.WithFields(log.Fields{"cmd": "state:" + d.command, "id": d.ClusterID}).
+		Info("Lock held by " + holder + " released")
+}
@@ -10,7 +10,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"os"
 	"regexp"
@@ -22,6 +22,9 @@ import (
 	log "github.com/Sirupsen/logrus"
 	ct "github.com/coreos/container-linux-config-transpiler/config"
 	"github.com/coreos/coreos-cloudinit/config/validate"
+
+	// Local:
+	"github.com/katosys/kato/pkg/kato"
 )
 
 //-----------------------------------------------------------------------------
@@ -45,11 +48,13 @@ type CmdFlags struct {
 	Domain              string   // --domain
 	Ec2Region           string   // --ec2-region
 	EtcdToken           string   // --etcd-token
+	FragmentsDir        string   // --fragments-dir
 	GzipUdata           bool     // --gzip-udata
 	HostID              string   // --host-id
 	HostName            string   // --host-name
 	IaasProvider        string   // --iaas-provider
 	MasterCount         int      // --master-count
+	OutputFormat        string   // --output-format
 	DNSProvider         string   // --dns-provider
 	DNSApiKey           string   // --dns-api-key
 	Prometheus          bool     // --prometheus
@@ -57,6 +62,8 @@ type CmdFlags struct {
 	RexrayEndpointIP    string   // --rexray-endpoint-ip
 	RexrayStorageDriver string   // --rexray-storage-driver
 	Roles               []string // --roles
+	SecretsURL          string   // --secrets-url
+	ServicesCatalog     string   // --services-catalog
 	SlackWebhook        string   // --slack-webhook
 	SMTPURL             string   // --smtp-url
 	StubZones           []string // --stub-zone
@@ -73,11 +80,19 @@ type PostProc struct {
 	HostTCPPorts  []string
 	HostUDPPorts  []string
 	MesosDNSPort  int
+	Metadata
 	SMTP
 	SystemdUnits []string
 	ZkServers    string
 }
 
+// Metadata structure
+type Metadata struct {
+	Platform    string
+	PrivateIPv4 string
+	PublicIPv4  string
+}
+
 // SMTP structure
 type SMTP struct {
 	Host string
@@ -90,7 +105,7 @@ type SMTP struct {
 type intData struct {
 	fragments fragmentSlice
 	services  serviceMap
-	template  string
+	sources   []source
 	userData  *bytes.Buffer
 }
 
@@ -98,15 +113,15 @@ type intData struct {
 // func: readFile
 //-----------------------------------------------------------------------------
 
-func readFile(path string) string {
+func readFile(path string) (string, error) {
 	if _, err := os.Stat(path); err == nil {
 		data, err := ioutil.ReadFile(path)
 		if err != nil {
-			log.WithField("cmd", "udata").Fatal(err)
+			return "", err
 		}
-		return string(data)
+		return string(data), nil
 	}
-	return ""
+	return "", nil
 }
 
 //-----------------------------------------------------------------------------
@@ -171,7 +186,7 @@ func alertManagers(masterCount int) (alertManagers string) {
 // func: smtpURLSplit
 //-----------------------------------------------------------------------------
 
-func smtpURLSplit(smtpURL string) (smtp SMTP) {
+func smtpURLSplit(smtpURL string) (smtp SMTP, err error) {
 	if smtpURL != "" {
 		r, _ := regexp.Compile("^smtp://(.+):(.+)@(.+):(\\d+)$")
 		if sub := r.FindStringSubmatch(smtpURL); sub != nil {
@@ -180,13 +195,34 @@ func smtpURLSplit(smtpURL string) (smtp SMTP) {
 			smtp.Host = sub[3]
 			smtp.Port = sub[4]
 		} else {
-			log.WithFields(log.Fields{"cmd": "udata", "id": smtpURL}).
-				Fatal("Invalid SMTP URL format.")
+			err = errors.New("Invalid SMTP URL format: " + smtpURL)
 		}
 	}
 	return
 }
 
+//-----------------------------------------------------------------------------
+// func: metadata
+//-----------------------------------------------------------------------------
+
+func metadata(iaasProvider string) (metadata Metadata) {
+	switch iaasProvider {
+	case "ec2":
+		metadata.Platform = "ec2"
+		metadata.PrivateIPv4 = "${COREOS_EC2_IPV4_LOCAL}"
+		metadata.PublicIPv4 = "${COREOS_EC2_IPV4_PUBLIC}"
+	case "pkt":
+		metadata.Platform = "packet"
+		metadata.PrivateIPv4 = "${COREOS_PACKET_IPV4_PRIVATE_0}"
+		metadata.PublicIPv4 = "${COREOS_PACKET_IPV4_PUBLIC_0}"
+	case "vbox":
+		metadata.Platform = "vagrant-virtualbox"
+		metadata.PrivateIPv4 = "${COREOS_VAGRANT_VIRTUALBOX_PRIVATE_IPV4}"
+		metadata.PublicIPv4 = "${COREOS_VAGRANT_VIRTUALBOX_PRIVATE_IPV4}"
+	}
+	return
+}
+
 //-----------------------------------------------------------------------------
 // func: mesosDNSPort
 //-----------------------------------------------------------------------------
@@ -231,15 +267,81 @@ func groups(prometheus bool) (groups []string) {
 	return
 }
 
+//-----------------------------------------------------------------------------
+// func: katoState
+//-----------------------------------------------------------------------------
+
+// katoState returns the cluster state shipped to the nodes, without its
+// secrets when they are delivered separately.
+func katoState(clusterID string, stripSecrets bool) (string, error) {
+
+	raw, err := kato.ReadState(clusterID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if !stripSecrets {
+		return string(raw), nil
+	}
+
+	state := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return "", err
+	}
+
+	for _, k := range kato.SecretFields {
+		delete(state, k)
+	}
+
+	out, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+//-----------------------------------------------------------------------------
+// func: secretRefs
+//-----------------------------------------------------------------------------
+
+// secretRefs replaces the secrets by references to the variables of the
+// secrets env file, which are expanded on boot by kato-env.service.
+func (d *CmdData) secretRefs() {
+
+	if d.SecretsURL == "" {
+		return
+	}
+
+	d.DNSApiKey = "${KATO_DNS_API_KEY}"
+
+	if d.SMTPURL != "" {
+		d.SMTP.Pass = "${KATO_SMTP_PASS}"
+	}
+
+	if d.SlackWebhook != "" {
+		d.SlackWebhook = "${KATO_SLACK_WEBHOOK}"
+	}
+}
+
 //-----------------------------------------------------------------------------
 // func: listOfTags
 //-----------------------------------------------------------------------------
 
 func (d *CmdData) listOfTags() (tags []string) {
 
-	tags = append(d.Roles, d.IaasProvider)
+	tags = append(d.Roles, "node", d.IaasProvider)
 	tags = append(tags, d.ClusterState)
 
+	for _, name := range d.Roles {
+		if role, ok := kato.GetRole(name); ok {
+			tags = append(tags, role.Tags...)
+		}
+	}
+
 	if d.CaCert != "" {
 		tags = append(tags, "cacert")
 	}
@@ -248,6 +350,10 @@ func (d *CmdData) listOfTags() (tags []string) {
 		tags = append(tags, "prometheus")
 	}
 
+	if d.SecretsURL != "" {
+		tags = append(tags, "secrets")
+	}
+
 	return
 }
 
@@ -261,11 +367,11 @@ func (d *CmdData) composeTemplate() {
 	tags := d.listOfTags()
 
 	// Apply the filter:
-	for _, frag := range d.fragments {
+	for i, frag := range d.fragments {
 		if frag.anyOf(tags) {
 			if frag.noneOf(tags) {
 				if frag.allOf(tags) {
-					d.template += frag.data
+					d.sources = append(d.sources, source{index: i})
 				}
 			}
 		}
@@ -276,128 +382,233 @@ func (d *CmdData) composeTemplate() {
 // func: renderTemplate
 //-----------------------------------------------------------------------------
 
-func (d *CmdData) renderTemplate() {
+func (d *CmdData) renderTemplate() error {
 
-	// Template parsing:
-	t := template.New("udata").Funcs(sprig.TxtFuncMap())
-	t, err := t.Parse(d.template)
-	if err != nil {
-		log.WithField("cmd", "udata").Fatal(err)
-	}
-
-	// Apply parsed template to data object:
 	d.userData = bytes.NewBuffer(make([]byte, 0, 65536))
-	if err = t.Execute(d.userData, d); err != nil {
-		log.WithField("cmd", "udata").Fatal(err)
+
+	// Render one fragment at a time:
+	for i := range d.sources {
+
+		// Keep track of where this fragment starts:
+		d.sources[i].line = bytes.Count(d.userData.Bytes(), []byte("\n")) + 1
+
+		// Template parsing:
+		name := "fragment-" + strconv.Itoa(d.sources[i].index)
+		t := template.New(name).Funcs(sprig.TxtFuncMap())
+		t, err := t.Parse(d.fragments[d.sources[i].index].data)
+		if err != nil {
+			return err
+		}
+
+		// Apply parsed template to data object:
+		if err = t.Execute(d.userData, d); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
 // func: renderIgnition
 //-----------------------------------------------------------------------------
 
-func (d *CmdData) renderIgnition() {
+func (d *CmdData) renderIgnition() error {
 
 	// Parse bytes into a Container Linux config:
 	config, ast, report := ct.Parse(d.userData.Bytes())
 	if report.IsFatal() {
-		log.WithField("cmd", "udata").Fatal(report.String())
+		return d.reportProblems(ctProblems(report))
 	}
 
 	// Convert Container Linux config into an Ignition config:
-	ign, report := ct.ConvertAs2_0(config, "", ast)
+	ign, report := ct.ConvertAs2_0(config, d.Platform, ast)
 	if report.IsFatal() {
-		log.WithField("cmd", "udata").Fatal(report.String())
+		return d.reportProblems(ctProblems(report))
 	}
 
 	// Convert Ignition config to JSON:
 	js, err := json.Marshal(ign)
 	if err != nil {
-		log.WithField("cmd", "udata").Fatal(err)
+		return err
 	}
 
 	// Write JSON to the userData buffer:
 	d.userData.Reset()
-	if _, err := d.userData.Write(js); err != nil {
-		log.WithField("cmd", "udata").Fatal(err)
-	}
+	_, err = d.userData.Write(js)
+	return err
 }
 
 //-----------------------------------------------------------------------------
 // func: validateUserData
 //-----------------------------------------------------------------------------
 
-func (d *CmdData) validateUserData() {
+func (d *CmdData) validateUserData() error {
+
+	switch d.OutputFormat {
+	case "cloud-config":
+		return d.validateCloudConfig()
+	default:
+		return d.validateContainerLinux()
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: validateContainerLinux
+//-----------------------------------------------------------------------------
+
+func (d *CmdData) validateContainerLinux() error {
 
-	errors := []string{}
+	// Parse bytes into a Container Linux config:
+	config, ast, report := ct.Parse(d.userData.Bytes())
+	problems := ctProblems(report)
+
+	// Convert it to find the Ignition level problems:
+	if !report.IsFatal() {
+		_, report = ct.ConvertAs2_0(config, d.Platform, ast)
+		problems = append(problems, ctProblems(report)...)
+	}
+
+	return d.reportProblems(problems)
+}
+
+//-----------------------------------------------------------------------------
+// func: validateCloudConfig
+//-----------------------------------------------------------------------------
+
+func (d *CmdData) validateCloudConfig() error {
 
 	report, err := validate.Validate(d.userData.Bytes())
 	if err != nil {
-		errors = append(errors, fmt.Sprintf("%v", err))
+		return err
 	}
-	for _, entry := range report.Entries() {
-		errors = append(errors, fmt.Sprintf("%v", entry))
-	}
-	if len(errors) > 0 {
-		log.WithField("cmd", "udata").Fatal(errors)
+
+	problems, err := cloudConfigProblems(report)
+	if err != nil {
+		return err
 	}
+
+	return d.reportProblems(problems)
 }
 
 //-----------------------------------------------------------------------------
 // func: outputUserData
 //-----------------------------------------------------------------------------
 
-func (d *CmdData) outputUserData() {
+func (d *CmdData) outputUserData() ([]byte, error) {
 
-	if d.GzipUdata {
-		log.WithFields(log.Fields{"cmd": "udata", "id": d.HostName + "-" + d.HostID}).
-			Info("Generating gzipped cloud-config user data")
-		w := gzip.NewWriter(os.Stdout)
-		if _, err := d.userData.WriteTo(w); err != nil {
-			_ = w.Close()
-			log.WithField("cmd", "udata").Fatal(err)
-		}
+	if !d.GzipUdata {
+		log.WithField("cmd", "udata").Info("Generating plain text " + d.OutputFormat + " user data")
+		return d.userData.Bytes(), nil
+	}
+
+	log.WithFields(log.Fields{"cmd": "udata", "id": d.HostName + "-" + d.HostID}).
+		Info("Generating gzipped " + d.OutputFormat + " user data")
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := d.userData.WriteTo(w); err != nil {
 		_ = w.Close()
-	} else {
-		log.WithField("cmd", "udata").Info("Generating plain text cloud-config user data")
-		if _, err := d.userData.WriteTo(os.Stdout); err != nil {
-			log.WithField("cmd", "udata").Fatal(err)
-		}
+		return nil, err
 	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 //-----------------------------------------------------------------------------
 // func: CmdRun
 //-----------------------------------------------------------------------------
 
-// CmdRun takes data from CmdData and outputs valid CoreOS cloud-config user
-// data in YAML format to stdout.
+// CmdRun takes data from CmdData and outputs valid CoreOS user data to stdout
+// in the cloud-config, Container Linux Config or Ignition format.
 func (d *CmdData) CmdRun() {
 
+	data, err := d.Render()
+	if err != nil {
+		log.WithField("cmd", "udata").Fatal(err)
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		log.WithField("cmd", "udata").Fatal(err)
+	}
+}
+
+//-----------------------------------------------------------------------------
+// func: Render
+//-----------------------------------------------------------------------------
+
+// Render takes data from CmdData and returns valid CoreOS user data in the
+// cloud-config, Container Linux Config or Ignition format.
+func (d *CmdData) Render() ([]byte, error) {
+
+	var err error
+
 	// Variables:
-	d.CaCert = readFile(d.CaCertPath)
-	d.KatoState = readFile(os.Getenv("HOME") + "/.kato/" + d.ClusterID + ".json")
+	if d.CaCert, err = readFile(d.CaCertPath); err != nil {
+		return nil, err
+	}
+	if d.KatoState, err = katoState(d.ClusterID, d.SecretsURL != ""); err != nil {
+		return nil, err
+	}
+	if d.SMTP, err = smtpURLSplit(d.SMTPURL); err != nil {
+		return nil, err
+	}
 	d.ZkServers = zkServers(d.QuorumCount)
 	d.EtcdServers = etcdServers(d.QuorumCount)
 	d.EtcdEndpoints = etcdEndpoints(d.QuorumCount)
 	d.AlertManagers = alertManagers(d.MasterCount)
-	d.SMTP = smtpURLSplit(d.SMTPURL)
+	d.Metadata = metadata(d.IaasProvider)
 	d.MesosDNSPort = mesosDNSPort(d.Roles)
 	d.Aliases = aliases(d.Roles, d.HostName)
+	d.secretRefs()
 
 	// Systemd units and ports:
-	d.services.load(d.Roles, groups(d.Prometheus))
+	if err := d.services.load(d.Roles, groups(d.Prometheus), d.OutputFormat); err != nil {
+		return nil, err
+	}
+	if d.ServicesCatalog != "" {
+		if err := d.services.loadCatalog(d.ServicesCatalog, d.Roles, groups(d.Prometheus)); err != nil {
+			return nil, err
+		}
+	}
 	d.SystemdUnits = d.services.listUnits()
 	d.HostTCPPorts = d.services.listPorts("tcp")
 	d.HostUDPPorts = d.services.listPorts("udp")
 
-	// Template to ignition JSON:
-	d.fragments.load2() // Load all fragments.
-	d.composeTemplate() // Compose the template.
-	d.renderTemplate()  // Container linux config.
-	d.renderIgnition()  // Ignition JSON.
+	// Load the fragments:
+	if d.OutputFormat == "cloud-config" {
+		d.fragments.load() // Cloud-config fragments.
+	} else {
+		d.fragments.load2() // Container linux fragments.
+	}
+
+	// Merge the custom fragments:
+	if d.FragmentsDir != "" {
+		if err := d.fragments.merge(d.FragmentsDir, d.OutputFormat); err != nil {
+			return nil, err
+		}
+	}
+
+	// Template to cloud-config or container linux config:
+	d.composeTemplate()
+	if err := d.renderTemplate(); err != nil {
+		return nil, err
+	}
+
+	// Validate the rendered user data:
+	if err := d.validateUserData(); err != nil {
+		return nil, err
+	}
+
+	// Container linux config to ignition JSON:
+	if d.OutputFormat == "ignition" {
+		if err := d.renderIgnition(); err != nil {
+			return nil, err
+		}
+	}
 
-	// User data:
-	d.validateUserData() // Validate the generated user data.
-	d.outputUserData()   // Output user data to stdout.
+	return d.outputUserData()
 }
@@ -0,0 +1,139 @@
+package udata
+
+//-----------------------------------------------------------------------------
+// Package factored import statement:
+//-----------------------------------------------------------------------------
+
+import (
+
+	// Stdlib:
+	"encoding/json"
+	"errors"
+
+	// Community:
+	log "github.com/Sirupsen/logrus"
+	"github.com/coreos/coreos-cloudinit/config/validate"
+	"github.com/coreos/ignition/config/validate/report"
+)
+
+//-----------------------------------------------------------------------------
+// Typedefs:
+//-----------------------------------------------------------------------------
+
+// Rendered fragment boundaries
+type source struct {
+	index int // Index in the fragment slice
+	line  int // First line in the rendered user data
+}
+
+// Validator agnostic report entry
+type problem struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+//-----------------------------------------------------------------------------
+// func: ctProblems
+//-----------------------------------------------------------------------------
+
+func ctProblems(r report.Report) (problems []problem) {
+	for _, entry := range r.Entries {
+		problems = append(problems, problem{
+			Kind:    entry.Kind.String(),
+			Message: entry.Message,
+			Line:    entry.Line,
+			Column:  entry.Column,
+		})
+	}
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: cloudConfigProblems
+//-----------------------------------------------------------------------------
+
+func cloudConfigProblems(r validate.Report) (problems []problem, err error) {
+
+	// Entry fields are only exposed through JSON:
+	for _, entry := range r.Entries() {
+		p := problem{}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: locate
+//-----------------------------------------------------------------------------
+
+// locate returns the source containing the given rendered line.
+func (d *CmdData) locate(line int) (src *source) {
+	for i := range d.sources {
+		if d.sources[i].line > line {
+			break
+		}
+		src = &d.sources[i]
+	}
+	return
+}
+
+//-----------------------------------------------------------------------------
+// func: reportProblems
+//-----------------------------------------------------------------------------
+
+// reportProblems logs every problem next to its originating fragment and
+// fails if any of them is an error.
+func (d *CmdData) reportProblems(problems []problem) error {
+
+	fatal := false
+
+	for _, p := range problems {
+
+		fields := log.Fields{"cmd": "udata", "format": d.OutputFormat}
+
+		// Map the rendered line to a fragment line:
+		if src := d.locate(p.Line); p.Line > 0 && src != nil {
+			frag := d.fragments[src.index]
+			fields["fragment"] = src.index
+			fields["name"] = frag.name
+			fields["line"] = p.Line - src.line + 1
+			fields["anyOf"] = frag.filter.anyOf
+			if len(frag.filter.noneOf) > 0 {
+				fields["noneOf"] = frag.filter.noneOf
+			}
+			if len(frag.filter.allOf) > 0 {
+				fields["allOf"] = frag.filter.allOf
+			}
+		}
+
+		if p.Column > 0 {
+			fields["column"] = p.Column
+		}
+
+		switch p.Kind {
+		case "error":
+			fatal = true
+			log.WithFields(fields).Error(p.Message)
+		case "warning":
+			log.WithFields(fields).Warn(p.Message)
+		default:
+			log.WithFields(fields).Info(p.Message)
+		}
+	}
+
+	if fatal {
+		return errors.New("Invalid user data")
+	}
+
+	return nil
+}
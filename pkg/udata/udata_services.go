@@ -7,8 +7,16 @@ package udata
 import (
 
 	// Stdlib:
+	"errors"
+	"io/ioutil"
 	"sort"
 	"strconv"
+
+	// Community:
+	"gopkg.in/yaml.v2"
+
+	// Local:
+	"github.com/katosys/kato/pkg/kato"
 )
 
 //-----------------------------------------------------------------------------
@@ -34,6 +42,26 @@ type startEnd struct {
 
 type serviceMap map[string]service
 
+// Catalog of user-defined services
+type catalog struct {
+	Services []catalogService `yaml:"services"`
+}
+
+type catalogService struct {
+	Name   string        `yaml:"name"`
+	Unit   string        `yaml:"unit"`
+	Roles  []string      `yaml:"roles"`
+	Groups []string      `yaml:"groups"`
+	Ports  []catalogPort `yaml:"ports"`
+}
+
+type catalogPort struct {
+	Start    int    `yaml:"start"`
+	End      int    `yaml:"end"`
+	Protocol string `yaml:"protocol"`
+	Ingress  string `yaml:"ingress"`
+}
+
 //-----------------------------------------------------------------------------
 // Custom sort:
 //-----------------------------------------------------------------------------
@@ -175,28 +203,14 @@ func (s *serviceMap) listPorts(protocol string) (list []string) {
 // func: load
 //-----------------------------------------------------------------------------
 
-func (s *serviceMap) load(roles, groups []string) {
-
-	// Map roles to services:
-	roleServices := map[string][]string{
-
-		"quorum": {
-			"docker", "rexray", "etchost", "zookeeper", "etcd-master", "rkt-api",
-			"cadvisor", "node-exporter", "zookeeper-exporter"},
+// load keeps the built-in services of the roles in the given groups, roles
+// must be registered.
+func (s *serviceMap) load(roles, groups []string, format string) error {
 
-		"master": {
-			"docker", "rexray", "etchost", "etcd-proxy", "calico", "mesos-dns",
-			"mesos-master", "marathon", "rkt-api", "cadvisor", "node-exporter",
-			"mesos-master-exporter", "confd", "alertmanager", "prometheus"},
-
-		"worker": {
-			"docker", "rexray", "etchost", "etcd-proxy", "calico", "go-dnsmasq",
-			"marathon-lb", "mesos-agent", "rkt-api", "cadvisor", "node-exporter",
-			"mesos-agent-exporter", "haproxy-exporter"},
-
-		"border": {
-			"docker", "rexray", "etchost", "etcd-proxy", "calico", "mongodb",
-			"pritunl", "rkt-api", "cadvisor", "node-exporter"},
+	// Etcd unit name:
+	etcd := "etcd-member.service"
+	if format == "cloud-config" {
+		etcd = "etcd2.service"
 	}
 
 	// Map services to config:
@@ -227,7 +241,7 @@ func (s *serviceMap) load(roles, groups []string) {
 		},
 
 		"etcd-proxy": {
-			name:   "etcd2.service",
+			name:   etcd,
 			groups: []string{"base"},
 			ports: []portRange{
 				{interval: startEnd{2379, 2379}, protocol: "tcp", ingress: ""},
@@ -253,7 +267,7 @@ func (s *serviceMap) load(roles, groups []string) {
 		},
 
 		"etcd-master": {
-			name:   "etcd2.service",
+			name:   etcd,
 			groups: []string{"base"},
 			ports: []portRange{
 				{interval: startEnd{2379, 2380}, protocol: "tcp", ingress: ""},
@@ -409,11 +423,80 @@ func (s *serviceMap) load(roles, groups []string) {
 
 	// Filter my services:
 	*s = serviceMap{}
-	for _, role := range roles {
-		for _, service := range roleServices[role] {
+	for _, name := range roles {
+		role, ok := kato.GetRole(name)
+		if !ok {
+			return errors.New("Unknown role " + name)
+		}
+		for _, service := range role.Services {
 			if findOne(serviceConfig[service].groups, groups) {
 				(*s)[service] = serviceConfig[service]
 			}
 		}
 	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// func: loadCatalog
+//-----------------------------------------------------------------------------
+
+// loadCatalog adds the user-defined services found in the YAML catalog at
+// path. A service with the same name as a built-in one overrides it.
+func (s *serviceMap) loadCatalog(path string, roles, groups []string) error {
+
+	// Read and parse the catalog:
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	c := catalog{}
+	if err := yaml.Unmarshal(raw, &c); err != nil {
+		return err
+	}
+
+	for _, cs := range c.Services {
+
+		// Mandatory fields:
+		if cs.Name == "" || cs.Unit == "" {
+			return errors.New("Catalog services need a name and a unit")
+		}
+
+		// Defaults:
+		if len(cs.Groups) == 0 {
+			cs.Groups = []string{"base"}
+		}
+
+		svc := service{name: cs.Unit, roles: cs.Roles, groups: cs.Groups}
+
+		for _, p := range cs.Ports {
+			if p.End == 0 {
+				p.End = p.Start
+			}
+			if p.Protocol == "" {
+				p.Protocol = "tcp"
+			}
+			if p.Start <= 0 || p.End < p.Start || p.End > 65535 {
+				return errors.New("Invalid port range in service " + cs.Name)
+			}
+			if p.Protocol != "tcp" && p.Protocol != "udp" {
+				return errors.New("Invalid protocol in service " + cs.Name + ": " + p.Protocol)
+			}
+			svc.ports = append(svc.ports, portRange{
+				interval: startEnd{p.Start, p.End},
+				protocol: p.Protocol,
+				ingress:  p.Ingress,
+			})
+		}
+
+		// Filter my services:
+		delete(*s, cs.Name)
+		if findOne(svc.roles, roles) && findOne(svc.groups, groups) {
+			(*s)[cs.Name] = svc
+		}
+	}
+
+	return nil
 }
@@ -1 +1,111 @@
 package udata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//-----------------------------------------------------------------------------
+// func: TestMergeFragments
+//-----------------------------------------------------------------------------
+
+func TestMergeFragments(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kato-fragments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"motd.yaml":       "---\nname: motd\nafter: /etc/hostname\n---\n    - path: /etc/motd # {{.HostName}}\n",
+		"plain.yaml":      "    - path: /etc/plain\n",
+		"hostname.yaml":   "---\nname: /etc/hostname\n---\n    - path: /etc/hostname # custom\n",
+		"masters.yaml":    "---\nanyOf: [master]\n---\n    - path: /etc/masters\n",
+		"not-worker.yaml": "---\nnoneOf: [worker]\n---\n    - path: /etc/not-worker\n",
+		"cloud.yaml":      "---\nformats: [cloud-config]\n---\n    - path: /etc/cloud\n",
+		".hidden.yaml":    "    - path: /etc/hidden\n",
+	}
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &CmdData{}
+	d.Roles = []string{"worker"}
+	d.HostName = "worker"
+	d.OutputFormat = "ignition"
+
+	d.fragments.load2()
+	embedded := len(d.fragments)
+	if err := d.fragments.merge(dir, d.OutputFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserted fragments land after 'after' or at the end:
+	if i := d.fragments.index("motd"); i != d.fragments.index("/etc/hostname")+1 {
+		t.Errorf("motd inserted at %d", i)
+	}
+
+	if n := len(d.fragments) - embedded; n != 4 {
+		t.Errorf("got %d new fragments, want 4", n)
+	}
+
+	// Fragments picked for a worker:
+	d.composeTemplate()
+	picked := map[string]bool{}
+	for _, s := range d.sources {
+		picked[d.fragments[s.index].name] = true
+	}
+
+	tests := []struct {
+		name   string
+		picked bool
+	}{
+		{"motd", true},          // Inserted without anyOf
+		{"plain", true},         // No front-matter at all
+		{"/etc/hostname", true}, // Override keeps the embedded filter
+		{"masters", false},
+		{"not-worker", false},
+		{"cloud", false},
+		{".hidden", false},
+	}
+
+	for _, tt := range tests {
+		if picked[tt.name] != tt.picked {
+			t.Errorf("%s: picked %v, want %v", tt.name, picked[tt.name], tt.picked)
+		}
+	}
+
+	// Render the custom fragments only:
+	d.sources = []source{
+		{index: d.fragments.index("/etc/hostname")},
+		{index: d.fragments.index("motd")},
+		{index: d.fragments.index("plain")},
+	}
+
+	if err := d.renderTemplate(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "\n    - path: /etc/hostname # custom\n    - path: /etc/motd # worker\n    - path: /etc/plain"
+	if got := d.userData.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Unknown 'after':
+	if err := ioutil.WriteFile(filepath.Join(dir, "orphan.yaml"),
+		[]byte("---\nafter: nope\n---\n    - path: /etc/orphan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.fragments.merge(dir, d.OutputFormat); err == nil ||
+		!strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected an unknown fragment error, got %v", err)
+	}
+}